@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"photo-deduplicator/internal/plan"
+	"sort"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// Apply subcommand, carries out a plan written by a --plan dry run
+// Usage: dedupe-agent apply [options] <plan>
+func apply(args []string) {
+
+	var (
+		help                bool
+		verbose             bool
+		logFileName         = ""
+		quarantineDirectory = ""
+	)
+
+	flags := getopt.New()
+	flags.SetProgram("dedupe-agent apply")
+	flags.SetParameters("<plan>")
+	flags.FlagLong(&help, "help", 'h', "Help")
+	flags.FlagLong(&verbose, "verbose", 'v', "Verbose printing")
+	flags.FlagLong(&logFileName, "logFile", 'L', "Log file")
+	flags.FlagLong(&quarantineDirectory, "quarantine", 'q', "Quarantine purged duplicates here instead of the directory in the plan")
+
+	flags.Parse(args)
+
+	// Print help and exit if help exists
+	if help {
+		flags.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+
+	if len(flags.Args()) != 1 {
+		flags.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	initializeLogging(logFileName, verbose)
+
+	planFileName := flags.Args()[0]
+	log.Info("Applying plan: ", planFileName)
+
+	loaded, err := plan.Load(planFileName)
+	if err != nil {
+		log.Errorf("Unable to read plan %s (%s)\n", planFileName, err.Error())
+		fmt.Printf("Unable to read plan %s (%s)\n", planFileName, err.Error())
+		os.Exit(1)
+	}
+
+	if quarantineDirectory != "" {
+		loaded.Quarantine = quarantineDirectory
+	}
+
+	result, err := plan.Apply(loaded)
+
+	for _, action := range result.Applied {
+		log.Debugf("Applied %s of %s\n", action.Type, action.Source)
+	}
+
+	skipped := make([]string, 0, len(result.Skipped))
+	for source := range result.Skipped {
+		skipped = append(skipped, source)
+	}
+	sort.Strings(skipped)
+	for _, source := range skipped {
+		fmt.Printf("Skipped: %s\n", result.Skipped[source])
+	}
+
+	for source, err := range result.Failed {
+		log.Errorf("Unable to apply action on %s (%s)\n", source, err.Error())
+		fmt.Printf("Failed: %s (%s)\n", source, err.Error())
+	}
+
+	fmt.Println("Applied", len(result.Applied), "of", len(loaded.Actions), "actions")
+	for _, manifest := range result.Manifests {
+		fmt.Println("Manifest written to", manifest)
+	}
+
+	if err != nil {
+		log.Errorf("Unable to apply plan %s (%s)\n", planFileName, err.Error())
+		fmt.Printf("Stopped applying %s (%s)\n", planFileName, err.Error())
+		os.Exit(1)
+	}
+
+	if len(result.Skipped) > 0 || len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"photo-deduplicator/internal/deduplicator"
+	"photo-deduplicator/internal/fileops"
+	"photo-deduplicator/internal/organize"
+	"photo-deduplicator/internal/plan"
+	"photo-deduplicator/internal/quarantine"
+	"photo-deduplicator/internal/report"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/pborman/getopt/v2"
 	"github.com/sirupsen/logrus"
 )
@@ -19,15 +28,54 @@ var log = logrus.New()
 
 func main() {
 
+	// Subcommands are dispatched before the deduplication flags are parsed
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			restore(os.Args[1:])
+			return
+		case "apply":
+			apply(os.Args[1:])
+			return
+		}
+	}
+
 	// Default values
 	var (
 		help                bool
 		verbose             bool
 		hashingRoutineCount = 4
 		inputDirectory      = "photos/"
+		extraRoots          []string
+		referenceRoots      []string
 		outputDirectory     = ""
+		nameTemplateText    = organize.DefaultNameTemplate
+		organizeByDate      = false
+		folderTemplateText  = organize.DefaultFolderTemplate
 		logFileName         = ""
 		purge               = false
+		quarantineDirectory = "quarantine/"
+		confirmDelete       = false
+		linkMode            = ""
+		verify              = false
+		hashAlgorithm       = deduplicator.SHA256.Name()
+		perceptualAlgorithm = deduplicator.PerceptualNone.String()
+		perceptualThreshold = deduplicator.DefaultPerceptualThreshold
+		contentHashing      = false
+		cacheFileName       = ""
+		snapshotFileName    = ""
+		loadIndexFileName   = ""
+		saveIndexFileName   = ""
+		allFiles            = false
+		includeExtensions   []string
+		excludeExtensions   []string
+		includePatterns     []string
+		excludePatterns     []string
+		keeperPolicyNames   []string
+		reportFileName      = ""
+		reportFormat        = ""
+		dryRun              = false
+		planFileName        = ""
 	)
 
 	// Take in arguments
@@ -35,9 +83,36 @@ func main() {
 	getopt.FlagLong(&verbose, "verbose", 'v', "Verbose printing")
 	getopt.FlagLong(&hashingRoutineCount, "hashingRoutineCount", 'c', "Number of routines hashing the files.")
 	getopt.FlagLong(&inputDirectory, "input", 'i', "Directory to deduplicate.")
+	getopt.FlagLong(&referenceRoots, "reference", 0, "Read-only reference library (comma separated), only copies of its files are purged or linked and it is never changed")
+	getopt.FlagLong(&extraRoots, "root", 0, "Also deduplicate these directories (comma separated), copies in --input and then earlier roots are kept")
 	getopt.FlagLong(&outputDirectory, "output", 'o', "Directory to store deduplicated files")
+	getopt.FlagLong(&nameTemplateText, "name", 'n', "Name of copied files, built from {name}, {hash}, {date}, {seq} and {uuid}")
+	getopt.FlagLong(&organizeByDate, "organize", 0, "Copy photos into dated folders of the output directory")
+	getopt.FlagLong(&folderTemplateText, "folders", 0, "Folders used by --organize, built from {year}, {month}, {day} and the --name tokens")
+	getopt.FlagLong(&reportFileName, "report", 'r', "Write a report of every file to this file")
+	getopt.FlagLong(&reportFormat, "report-format", 0, "Format of the report (json, csv, ndjson, html), guessed from the report's extension by default")
+	getopt.FlagLong(&dryRun, "dry-run", 0, "Only print what would be copied or purged, the filesystem is left untouched")
+	getopt.FlagLong(&planFileName, "plan", 0, "Write what would be done to this plan file for dedupe-agent apply, implies --dry-run")
 	getopt.FlagLong(&logFileName, "logFile", 'L', "Log file")
-	getopt.FlagLong(&purge, "purge", 'p', "Purge deduplicated files")
+	getopt.FlagLong(&purge, "purge", 'p', "Purge duplicate files by moving them to the quarantine directory")
+	getopt.FlagLong(&hashAlgorithm, "hash", 0, "Hash algorithm ("+strings.Join(deduplicator.HasherNames(), ", ")+")")
+	getopt.FlagLong(&allFiles, "all-files", 0, "Deduplicate every file, not only images and videos")
+	getopt.FlagLong(&includeExtensions, "include-ext", 0, "Only deduplicate files with these extensions (comma separated)")
+	getopt.FlagLong(&excludeExtensions, "exclude-ext", 0, "Skip files with these extensions (comma separated)")
+	getopt.FlagLong(&includePatterns, "include", 0, "Only deduplicate files matching these globs (comma separated)")
+	getopt.FlagLong(&excludePatterns, "exclude", 0, "Skip files matching these globs (comma separated)")
+	getopt.FlagLong(&contentHashing, "content", 0, "Hash only JPEG/PNG image data so metadata edits are still duplicates")
+	getopt.FlagLong(&cacheFileName, "cache", 0, "Keep hashes in this file so unchanged files are not hashed again on the next run")
+	getopt.FlagLong(&loadIndexFileName, "load-index", 0, "Treat copies of the originals in this index file as duplicates, even when the originals are elsewhere")
+	getopt.FlagLong(&saveIndexFileName, "save-index", 0, "Write the hash of every original to this index file for --load-index")
+	getopt.FlagLong(&snapshotFileName, "incremental", 0, "Only process files that are new or changed since the run that wrote this snapshot file")
+	getopt.FlagLong(&perceptualAlgorithm, "perceptual", 0, "Perceptual hash used to find near duplicates (none, ahash, dhash, phash)")
+	getopt.FlagLong(&perceptualThreshold, "threshold", 0, "Maximum perceptual hash distance (0-64) for near duplicates")
+	getopt.FlagLong(&keeperPolicyNames, "keep", 0, "Which copy of a duplicate is kept (oldest, shortest-path, resolution, metadata, largest, prefix:<dir>), comma separated in order of priority")
+	getopt.FlagLong(&verify, "verify", 0, "Compare duplicates byte for byte before reporting them")
+	getopt.FlagLong(&quarantineDirectory, "quarantine", 'q', "Directory purged duplicates and their manifest are moved to")
+	getopt.FlagLong(&confirmDelete, "confirm-delete", 0, "Permanently delete duplicates when purging instead of quarantining them")
+	getopt.FlagLong(&linkMode, "link", 0, "Replace duplicates with a link to the kept copy ("+strings.Join(fileops.LinkModes(), ", ")+")")
 
 	// Parse arguments
 	getopt.Parse()
@@ -49,55 +124,86 @@ func main() {
 	}
 
 	// Initialize logging
-
-	// See if a log file was provided
-	if logFileName != "" {
-		logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err == nil {
-			log.Out = logFile
-		} else {
-			log.Info("Failed to log to file, using default stderr")
-		}
-	}
-	log.WithFields(logrus.Fields{"agent": "main"})
-
-	// Set to verbose log level if turned on
-	if verbose {
-		log.SetLevel(logrus.DebugLevel)
-		log.Debug("Debug level set")
-
-	}
+	initializeLogging(logFileName, verbose)
 
 	// List out the arguments
 	log.Info("**Application Configuration**")
 	log.Info("Hashing Routines: ", hashingRoutineCount)
 	log.Info("Input Directory: ", inputDirectory)
+	log.Info("Roots: ", extraRoots)
+	log.Info("Reference: ", referenceRoots)
 	log.Info("Output Directory: ", outputDirectory)
+	log.Info("Name Template: ", nameTemplateText)
+	log.Info("Organize: ", strconv.FormatBool(organizeByDate))
+	log.Info("Folder Template: ", folderTemplateText)
+	log.Info("Hash Algorithm: ", hashAlgorithm)
+	log.Info("All Files: ", strconv.FormatBool(allFiles))
+	log.Info("Include Extensions: ", includeExtensions)
+	log.Info("Exclude Extensions: ", excludeExtensions)
+	log.Info("Include Patterns: ", includePatterns)
+	log.Info("Exclude Patterns: ", excludePatterns)
+	log.Info("Content Hashing: ", strconv.FormatBool(contentHashing))
+	log.Info("Hash Cache: ", cacheFileName)
+	log.Info("Incremental Snapshot: ", snapshotFileName)
+	log.Info("Load Index: ", loadIndexFileName)
+	log.Info("Save Index: ", saveIndexFileName)
+	log.Info("Perceptual Algorithm: ", perceptualAlgorithm)
+	log.Info("Perceptual Threshold: ", perceptualThreshold)
+	log.Info("Keeper Policies: ", keeperPolicyNames)
+	log.Info("Verify: ", strconv.FormatBool(verify))
 	log.Info("Purge: ", strconv.FormatBool(purge))
+	log.Info("Quarantine Directory: ", quarantineDirectory)
+	log.Info("Confirm Delete: ", strconv.FormatBool(confirmDelete))
+	log.Info("Link: ", linkMode)
+	log.Info("Report: ", reportFileName)
+	log.Info("Report Format: ", reportFormat)
+	log.Info("Dry Run: ", strconv.FormatBool(dryRun))
+	log.Info("Plan: ", planFileName)
 	log.Info("Log file: ", logFileName)
 
 	// Data validation
 
 	// Verify info
-	inputDirectoryInfo, err := os.Stat(inputDirectory)
-	if err != nil {
-		// Error trying to read directory
-		log.Errorf("Error reading the input directory %s\n", inputDirectory)
-		log.Errorf("%s\n", err.Error())
-		fmt.Printf("Error reading from input directory %s\n", inputDirectory)
-		return
+	roots := append([]string{inputDirectory}, extraRoots...)
+	for _, root := range append(roots, referenceRoots...) {
+		inputDirectoryInfo, err := os.Stat(root)
+		if err != nil {
+			// Error trying to read directory
+			log.Errorf("Error reading the input directory %s\n", root)
+			log.Errorf("%s\n", err.Error())
+			fmt.Printf("Error reading from input directory %s\n", root)
+			return
+		}
+		if !inputDirectoryInfo.IsDir() {
+			// Not valid directory
+			log.Errorf("Input directory (%s) is not a directory\n", root)
+			fmt.Printf("Looks like %s is not a directory. Exiting\n", root)
+			return
+		}
 	}
-	if !inputDirectoryInfo.IsDir() {
-		// Not valid directory
-		log.Errorf("Input directory (%s) is not a directory\n", inputDirectory)
-		fmt.Printf("Looks like %s is not a directory. Exiting\n", inputDirectory)
-		return
+
+	// A plan is always a dry run
+	if planFileName != "" {
+		dryRun = true
+	}
+
+	// Copies are written while the input is walked, they would be found as duplicates of their sources.
+	// Nothing is ever written into the reference library either.
+	for _, root := range append(roots, referenceRoots...) {
+		if outputDirectory != "" && isSubdirectory(root, outputDirectory) {
+			log.Errorf("Output directory (%s) is inside the input directory (%s)\n", outputDirectory, root)
+			fmt.Printf("Output directory %s can not be inside of %s. Exiting\n", outputDirectory, root)
+			return
+		}
 	}
 
 	if outputDirectory != "" {
 		// validate output directory if it exists
 		outputDirectoryInfo, err := os.Stat(outputDirectory)
-		if errors.Is(err, os.ErrNotExist) {
+		if errors.Is(err, os.ErrNotExist) && dryRun {
+			// Created when the plan is applied
+			log.Info("Output directory ", outputDirectory, " does not exist yet")
+		} else if errors.Is(err, os.ErrNotExist) {
 			// Create a new directory
 			err := os.Mkdir(outputDirectory, 0750)
 			if err != nil && !os.IsExist(err) {
@@ -118,88 +224,615 @@ func main() {
 		}
 	}
 
+	nameTemplate, err := organize.ParseTemplate(nameTemplateText)
+	if err != nil {
+		log.Errorf("%s\n", err.Error())
+		fmt.Printf("Invalid name template %s. Exiting\n", nameTemplateText)
+		return
+	}
+	namer, err := organize.NewNamer(outputDirectory, nameTemplate)
+	if err != nil {
+		log.Errorf("%s\n", err.Error())
+		fmt.Printf("Invalid name template %s. Exiting\n", nameTemplateText)
+		return
+	}
+	if organizeByDate {
+		folderTemplate, err := organize.ParseTemplate(folderTemplateText)
+		if err != nil {
+			log.Errorf("%s\n", err.Error())
+			fmt.Printf("Invalid folder template %s. Exiting\n", folderTemplateText)
+			return
+		}
+		namer.SetFolders(folderTemplate)
+	}
+
+	hasher, err := deduplicator.HasherByName(hashAlgorithm)
+	if err != nil {
+		log.Errorf("%s\n", err.Error())
+		fmt.Printf("Unknown hash algorithm %s. Exiting\n", hashAlgorithm)
+		return
+	}
+
+	perceptual, err := deduplicator.PerceptualAlgorithmByName(perceptualAlgorithm)
+	if err != nil {
+		log.Errorf("%s\n", err.Error())
+		fmt.Printf("Unknown perceptual algorithm %s. Exiting\n", perceptualAlgorithm)
+		return
+	}
+
+	if perceptualThreshold < 0 || perceptualThreshold > deduplicator.MaxPerceptualThreshold {
+		log.Errorf("Invalid perceptual threshold %d\n", perceptualThreshold)
+		fmt.Printf("--threshold must be between 0 and %d. Exiting\n", deduplicator.MaxPerceptualThreshold)
+		return
+	}
+
+	var keeperPolicies []deduplicator.KeeperPolicy
+	for _, name := range keeperPolicyNames {
+		policy, err := deduplicator.KeeperPolicyByName(name)
+		if err != nil {
+			log.Errorf("%s\n", err.Error())
+			fmt.Printf("Unknown keeper policy %s. Exiting\n", name)
+			return
+		}
+		keeperPolicies = append(keeperPolicies, policy)
+	}
+
+	if organizeByDate && outputDirectory == "" {
+		log.Errorf("--organize given without --output\n")
+		fmt.Printf("--organize only applies to --output. Exiting\n")
+		return
+	}
+
+	if confirmDelete && !purge {
+		log.Errorf("--confirm-delete given without --purge\n")
+		fmt.Printf("--confirm-delete only applies to --purge. Exiting\n")
+		return
+	}
+
+	if linkMode != "" {
+		known := false
+		for _, mode := range fileops.LinkModes() {
+			known = known || linkMode == mode
+		}
+		if !known {
+			log.Errorf("Unknown link mode %s\n", linkMode)
+			fmt.Printf("Unknown link mode %s. Exiting\n", linkMode)
+			return
+		}
+		if purge {
+			log.Errorf("--link given with --purge\n")
+			fmt.Printf("Duplicates can either be purged or linked, not both. Exiting\n")
+			return
+		}
+		// Writing through a hardlink or symlink would change the reference file
+		if len(referenceRoots) > 0 && linkMode != fileops.Reflink {
+			log.Errorf("--link %s given with --reference\n", linkMode)
+			fmt.Printf("Only reflinks keep the reference library read-only, use --link %s. Exiting\n", fileops.Reflink)
+			return
+		}
+	}
+
+	var purger *quarantine.Quarantine
+	// Only set on a dry run, collects every action instead of taking it
+	var dryRunPlan *plan.Plan
+	if dryRun {
+		if purge {
+			dryRunPlan = plan.New(quarantineDirectory)
+		} else {
+			dryRunPlan = plan.New("")
+		}
+	}
+
+	if purge {
+		// The quarantine can not live inside the input or we would walk into it
+		for _, root := range append(roots, referenceRoots...) {
+			if isSubdirectory(root, quarantineDirectory) {
+				log.Errorf("Quarantine directory (%s) is inside the input directory (%s)\n", quarantineDirectory, root)
+				fmt.Printf("Quarantine directory %s can not be inside of %s. Exiting\n", quarantineDirectory, root)
+				return
+			}
+		}
+	}
+
+	if purge && !dryRun {
+		purger, err = quarantine.New(quarantineDirectory, confirmDelete)
+		if err != nil {
+			log.Errorf("Unable to create quarantine in %s (%s)\n", quarantineDirectory, err.Error())
+			fmt.Printf("Unable to create quarantine in %s. Exiting\n", quarantineDirectory)
+			return
+		}
+		log.Info("Quarantine run directory: ", purger.RunDirectory())
+	}
+
+	var reporter report.Writer
+	if reportFileName != "" {
+		if reportFormat == "" {
+			reportFormat = report.FormatFromPath(reportFileName)
+		}
+		reporter, err = report.Create(reportFileName, reportFormat)
+		if err != nil {
+			log.Errorf("Unable to create report %s (%s)\n", reportFileName, err.Error())
+			fmt.Printf("Unable to create report %s. Exiting\n", reportFileName)
+			return
+		}
+	}
+
+	var hashCache *deduplicator.HashCache
+	if cacheFileName != "" {
+		hashCache, err = deduplicator.OpenHashCache(cacheFileName)
+		if err != nil {
+			log.Errorf("Unable to open hash cache %s (%s)\n", cacheFileName, err.Error())
+			fmt.Printf("Unable to open hash cache %s. Exiting\n", cacheFileName)
+			return
+		}
+	}
+
+	var previousSnapshot *deduplicator.Snapshot
+	if snapshotFileName != "" {
+		previousSnapshot, err = deduplicator.LoadSnapshot(snapshotFileName)
+		if errors.Is(err, os.ErrNotExist) {
+			// First run, everything is new
+			log.Info("No snapshot in ", snapshotFileName, " yet, scanning everything")
+		} else if err != nil {
+			log.Errorf("Unable to read snapshot %s (%s)\n", snapshotFileName, err.Error())
+			fmt.Printf("Unable to read snapshot %s. Exiting\n", snapshotFileName)
+			return
+		}
+	}
+
 	// Start deduplication
-	deduper := deduplicator.New(inputDirectory, hashingRoutineCount)
+	options := []deduplicator.Option{
+		deduplicator.WithHasher(hasher),
+		deduplicator.WithPerceptual(perceptual, perceptualThreshold),
+	}
+	if contentHashing {
+		options = append(options, deduplicator.WithContentHashing())
+	}
+	if len(extraRoots) > 0 {
+		options = append(options, deduplicator.WithRoots(extraRoots...))
+	}
+	if len(referenceRoots) > 0 {
+		options = append(options, deduplicator.WithReference(referenceRoots...))
+	}
+	if previousSnapshot != nil {
+		options = append(options, deduplicator.WithIncremental(previousSnapshot))
+	}
+	if hashCache != nil {
+		options = append(options, deduplicator.WithHashCache(hashCache))
+	}
+	if len(keeperPolicies) > 0 {
+		options = append(options, deduplicator.WithKeeperPolicy(keeperPolicies...))
+	}
+	// Files with a unique size are never hashed unless we name copies after their hash,
+	// report every hash or index every original
+	if (outputDirectory != "" && namer.NeedsHash()) || reporter != nil || saveIndexFileName != "" {
+		options = append(options, deduplicator.WithFullHashing())
+	}
+	// Capture times are read by the hashing workers
+	if (outputDirectory != "" && namer.NeedsCaptureTime()) || reportFormat == report.FormatHTML {
+		options = append(options, deduplicator.WithExif())
+	}
+
+	// Only media files by default, user supplied rules are added on top
+	filter := deduplicator.DefaultFilter()
+	if allFiles {
+		filter = &deduplicator.Filter{}
+	}
+	filter.IncludeExtensions = append(filter.IncludeExtensions, includeExtensions...)
+	filter.ExcludeExtensions = append(filter.ExcludeExtensions, excludeExtensions...)
+	filter.IncludePatterns = append(filter.IncludePatterns, includePatterns...)
+	filter.ExcludePatterns = append(filter.ExcludePatterns, excludePatterns...)
+	options = append(options, deduplicator.WithFilter(filter))
+
+	deduper := deduplicator.New(inputDirectory, hashingRoutineCount, options...)
 	deduper.SetBufferSize(50)
+	deduper.SetVerify(verify)
+	if loadIndexFileName != "" {
+		loaded, err := deduper.LoadIndex(loadIndexFileName)
+		if err != nil {
+			log.Errorf("Unable to load index %s (%s)\n", loadIndexFileName, err.Error())
+			fmt.Printf("Unable to load index %s (%s). Exiting\n", loadIndexFileName, err.Error())
+			return
+		}
+		fmt.Println("Loaded", loaded, "originals from", loadIndexFileName)
+	}
 	photoChannel := make(chan deduplicator.DedupeFileMetadata, 100)
 	var photoWaitGroup sync.WaitGroup
 
-	deduper.Serve(photoChannel, &photoWaitGroup)
+	// The first interrupt stops the scan once the current file is done, a second one quits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Info("Interrupted, stopping after the current file")
+		fmt.Println("Interrupted, finishing the current file. Interrupt again to quit immediately")
+	}()
+
+	deduper.Serve(ctx, photoChannel, &photoWaitGroup)
 
 	totalDuplicates := 0
+	totalCopied := 0
+	totalPurged := 0
+	totalCollisions := 0
+	totalLinked := 0
+	totalInReference := 0
+	var reclaimedBytes int64
+	totalNearDuplicates := 0
 
 	failedCopies := []deduplicator.DedupeFileMetadata{}
+	failedPurges := []deduplicator.DedupeFileMetadata{}
+	failedLinks := []deduplicator.DedupeFileMetadata{}
+	// Left untouched because the filesystem can not link them
+	unsupportedLinks := 0
+	// Duplicates left alone because their kept copy is not on this machine
+	missingKeepers := 0
 
 	// Process photo channel
 	for photoMetadata := range photoChannel {
 
-		if photoMetadata.DuplicatePath != "" {
-			totalDuplicates += 1
-			fmt.Printf("%s is a duplicate of %s\n", photoMetadata.Path, photoMetadata.DuplicatePath)
+		// Interrupted, whatever is still served is left alone but still reported
+		if ctx.Err() != nil {
+			writeRecord(reporter, photoMetadata, report.ActionNone, "")
 			continue
 		}
 
-		if outputDirectory == "" {
-			continue
+		if photoMetadata.Status == deduplicator.StatusHashCollision {
+			totalCollisions += 1
+			fmt.Printf("%s has the same hash as %s but different contents\n", photoMetadata.Path, photoMetadata.CollisionPath)
 		}
 
-		// Copy the file to the new directory
-		sourceFile, err := os.OpenFile(photoMetadata.Path, os.O_RDWR, 0666)
+		if photoMetadata.Status == deduplicator.StatusNearDuplicate {
+			totalNearDuplicates += 1
+			fmt.Printf("%s looks like %s (%.0f%% similar)\n", photoMetadata.Path, photoMetadata.SimilarPath, photoMetadata.Similarity*100)
+		}
 
-		if err != nil {
-			// Error opening the file
-			failedCopies = append(failedCopies, photoMetadata)
-			log.Errorf("Error opening source photo (%s) (%s)\n", photoMetadata.Path, err.Error())
-			continue
+		// What happened to the file, for the report
+		action := report.ActionNone
+		destination := ""
+
+		if photoMetadata.DuplicatePath != "" {
+			totalDuplicates += 1
+			inReference := deduper.InReference(photoMetadata.DuplicatePath)
+			if inReference {
+				totalInReference += 1
+				fmt.Printf("%s is already in the reference library as %s\n", photoMetadata.Path, photoMetadata.DuplicatePath)
+			} else {
+				fmt.Printf("%s is a duplicate of %s\n", photoMetadata.Path, photoMetadata.DuplicatePath)
+			}
+
+			if len(referenceRoots) > 0 && !inReference {
+				// With a reference library only copies of its files are acted on
+				log.Debugf("Leaving %s alone, %s is not in the reference library\n", photoMetadata.Path, photoMetadata.DuplicatePath)
+			} else if _, err := os.Stat(photoMetadata.DuplicatePath); err != nil && (purge || linkMode != "") {
+				// Originals loaded from an index may be on another machine, the duplicate could be the only copy here
+				log.Errorf("Leaving %s alone, its kept copy %s can not be read (%s)\n", photoMetadata.Path, photoMetadata.DuplicatePath, err.Error())
+				missingKeepers += 1
+			} else if purge && dryRunPlan != nil {
+				purgeAction := plan.ActionQuarantine
+				if confirmDelete {
+					purgeAction = plan.ActionDelete
+				}
+				dryRunPlan.Add(plannedAction(purgeAction, photoMetadata, ""))
+				action = report.ActionPlanned
+			} else if linkMode != "" && dryRunPlan != nil {
+				dryRunPlan.Add(plannedAction(linkMode, photoMetadata, ""))
+				action = report.ActionPlanned
+			} else if linkMode != "" {
+				err := fileops.ReplaceWithLink(photoMetadata.Path, photoMetadata.DuplicatePath, linkMode)
+				if errors.Is(err, fileops.ErrAlreadyLinked) {
+					log.Debugf("%s already is %s\n", photoMetadata.Path, photoMetadata.DuplicatePath)
+				} else if errors.Is(err, fileops.ErrLinkUnsupported) {
+					log.Errorf("Left %s untouched (%s)\n", photoMetadata.Path, err.Error())
+					unsupportedLinks += 1
+				} else if err != nil {
+					log.Errorf("Unable to link %s to %s (%s)\n", photoMetadata.Path, photoMetadata.DuplicatePath, err.Error())
+					failedLinks = append(failedLinks, photoMetadata)
+					action = report.ActionFailed
+				} else {
+					log.Debugf("Replaced %s with a %s to %s\n", photoMetadata.Path, linkMode, photoMetadata.DuplicatePath)
+					totalLinked += 1
+					reclaimedBytes += photoMetadata.Size
+					action = linkedAction(linkMode)
+					destination = photoMetadata.DuplicatePath
+				}
+			} else if purger != nil {
+				entry, err := purger.Purge(photoMetadata.Path, photoMetadata.DuplicatePath)
+				if err != nil {
+					log.Errorf("Unable to purge %s (%s)\n", photoMetadata.Path, err.Error())
+					failedPurges = append(failedPurges, photoMetadata)
+					action = report.ActionFailed
+				} else {
+					log.Debugf("Purged %s (%s)\n", entry.OriginalPath, entry.Action)
+					totalPurged += 1
+					action = entry.Action
+					destination = entry.QuarantinePath
+				}
+			}
+		} else if outputDirectory != "" && dryRunPlan != nil {
+			destinationFileName, err := outputName(namer, photoMetadata)
+			if err != nil {
+				failedCopies = append(failedCopies, photoMetadata)
+				action = report.ActionFailed
+			} else {
+				dryRunPlan.Add(plannedAction(plan.ActionCopy, photoMetadata, destinationFileName))
+				action = report.ActionPlanned
+				destination = destinationFileName
+			}
+		} else if outputDirectory != "" {
+			destinationFileName, err := copyToOutput(namer, photoMetadata)
+			if err != nil {
+				failedCopies = append(failedCopies, photoMetadata)
+				action = report.ActionFailed
+			} else {
+				log.Debugf("Copied %s to %s\n", photoMetadata.Path, destinationFileName)
+				totalCopied += 1
+				action = report.ActionCopied
+				destination = destinationFileName
+			}
 		}
 
-		uuid, err := uuid.NewRandom()
+		writeRecord(reporter, photoMetadata, action, destination)
+	}
 
-		if err != nil {
-			// Error generating UUID
-			log.Errorf("Error generating uuid for photo name (%s)\n", err.Error())
-			failedCopies = append(failedCopies, photoMetadata)
-			continue
+	fmt.Println("Deduplicated", totalDuplicates, "photos in", len(deduper.Groups()), "groups")
+	if len(referenceRoots) > 0 {
+		fmt.Println("Found", totalInReference, "photos already in the reference library")
+	}
+	if dryRunPlan != nil {
+		dryRunPlan.Print(os.Stdout)
+		counts := dryRunPlan.Counts()
+		if linkMode != "" {
+			fmt.Println("Would copy", counts[plan.ActionCopy], "photos and replace", counts[linkMode], "duplicates with a", linkMode)
+		} else {
+			fmt.Println("Would copy", counts[plan.ActionCopy], "photos, quarantine", counts[plan.ActionQuarantine],
+				"and delete", counts[plan.ActionDelete], "duplicates")
+		}
+		fmt.Println("Would reclaim", dryRunPlan.ReclaimedBytes(), "bytes")
+		if len(failedCopies) > 0 {
+			fmt.Println("Unable to plan", len(failedCopies), "copies")
+		}
+	} else if outputDirectory != "" {
+		fmt.Println("Copied", totalCopied, "photos to", outputDirectory)
+		if len(failedCopies) > 0 {
+			fmt.Println("Failed to copy", len(failedCopies), "photos")
+		}
+	}
+	if linkMode != "" && dryRunPlan == nil {
+		fmt.Println("Replaced", totalLinked, "duplicates with a", linkMode+", reclaiming", reclaimedBytes, "bytes")
+		if unsupportedLinks > 0 {
+			fmt.Println("Left", unsupportedLinks, "duplicates untouched, the filesystem can not", linkMode, "them")
 		}
+		if len(failedLinks) > 0 {
+			fmt.Println("Failed to link", len(failedLinks), "duplicates")
+		}
+	}
+	if missingKeepers > 0 {
+		fmt.Println("Left", missingKeepers, "duplicates alone, their kept copy can not be read on this machine")
+	}
+	if perceptual != deduplicator.PerceptualNone {
+		fmt.Println("Found", totalNearDuplicates, "near duplicates")
+	}
+	if totalCollisions > 0 {
+		fmt.Println("Found", totalCollisions, "hash collisions with different contents")
+	}
 
-		destinationFileName := filepath.Join(outputDirectory, (uuid.String() + ".jpg"))
+	photoWaitGroup.Wait()
 
-		destinationFile, err := os.OpenFile(destinationFileName, os.O_RDWR|os.O_CREATE, 0666)
-		if err != nil {
-			log.Errorf("Unable to open destination file %s(%s)\n", destinationFileName, err.Error())
-			failedCopies = append(failedCopies, photoMetadata)
-			continue
+	// Summarise what never made it into the pipeline
+	skippedFiles := deduper.SkippedFiles()
+	skippedReasons := make([]string, 0, len(skippedFiles))
+	for reason := range skippedFiles {
+		skippedReasons = append(skippedReasons, reason)
+	}
+	sort.Strings(skippedReasons)
+	for _, reason := range skippedReasons {
+		fmt.Printf("Skipped %d files (%s)\n", skippedFiles[reason], reason)
+	}
+
+	if previousSnapshot != nil {
+		changes := deduper.Changes()
+		fmt.Println("Since the last run:", len(changes.New), "new,", len(changes.Modified), "modified,",
+			len(changes.Moved), "moved and", len(changes.Deleted), "deleted files")
+	}
+
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		fmt.Println("Interrupted before every photo was processed")
+	}
+
+	// A dry run or an interrupted run left files alone, the next run has to look at them again
+	if snapshotFileName != "" && !dryRun && !interrupted {
+		if err := deduper.Snapshot().Save(snapshotFileName); err != nil {
+			log.Errorf("Unable to write snapshot %s (%s)\n", snapshotFileName, err.Error())
+			fmt.Println("Unable to write snapshot", snapshotFileName)
+		} else {
+			fmt.Println("Snapshot written to", snapshotFileName)
 		}
+	}
 
-		// Copy the photo
-		if _, err := io.Copy(destinationFile, sourceFile); err != nil {
-			log.Errorf("Unable to copy %s to %s (%s)\n", photoMetadata.Path, destinationFile, err.Error())
-			failedCopies = append(failedCopies, photoMetadata)
-			continue
+	// An incomplete index would replace a complete one
+	if saveIndexFileName != "" && !interrupted {
+		if err := deduper.SaveIndex(saveIndexFileName); err != nil {
+			log.Errorf("Unable to write index %s (%s)\n", saveIndexFileName, err.Error())
+			fmt.Println("Unable to write index", saveIndexFileName)
+		} else {
+			fmt.Println("Index written to", saveIndexFileName)
 		}
+	}
 
-		// Flush to disk
-		if err := destinationFile.Sync(); err != nil {
-			log.Errorf("Unable to flush %s to disk (%s)\n", destinationFileName, err.Error())
-			failedCopies = append(failedCopies, photoMetadata)
-			continue
+	if hashCache != nil {
+		hits, misses := hashCache.Stats()
+		if err := hashCache.Save(); err != nil {
+			log.Errorf("Unable to write hash cache %s (%s)\n", cacheFileName, err.Error())
+			fmt.Println("Unable to write hash cache", cacheFileName)
+		} else {
+			fmt.Println("Reused", hits, "hashes from", cacheFileName+",", "hashed", misses)
 		}
+	}
 
-		// Close file
+	if reporter != nil {
+		if err := reporter.Close(); err != nil {
+			log.Errorf("Unable to write report %s (%s)\n", reportFileName, err.Error())
+			fmt.Println("Unable to write report", reportFileName)
+		} else {
+			fmt.Println("Report written to", reportFileName)
+		}
+	}
 
-		if err := destinationFile.Close(); err != nil {
-			log.Errorf("Unable to close %s (%s)\n", destinationFileName, err.Error())
-			continue
+	// Applying half a plan would look like a finished run
+	if planFileName != "" && !interrupted {
+		if err := dryRunPlan.Save(planFileName); err != nil {
+			log.Errorf("Unable to write plan %s (%s)\n", planFileName, err.Error())
+			fmt.Println("Unable to write plan", planFileName)
+		} else {
+			fmt.Printf("Plan written to %s, run dedupe-agent apply %s to carry it out\n", planFileName, planFileName)
 		}
+	}
 
-		if purge {
-			// delete the old file
+	if purger != nil {
+		if err := purger.Close(); err != nil {
+			log.Errorf("Unable to close manifest %s (%s)\n", purger.ManifestPath(), err.Error())
 		}
 
+		if confirmDelete {
+			fmt.Println("Deleted", totalPurged, "duplicates")
+		} else {
+			fmt.Println("Quarantined", totalPurged, "duplicates in", purger.RunDirectory())
+		}
+		fmt.Println("Manifest written to", purger.ManifestPath())
+
+		if len(failedPurges) > 0 {
+			fmt.Println("Failed to purge", len(failedPurges), "duplicates")
+		}
+	}
+
+	if interrupted {
+		os.Exit(130)
 	}
+}
 
-	fmt.Println("Deduplicated", totalDuplicates, "photos")
+// Point the logger at a log file (if provided) and set the log level
+func initializeLogging(logFileName string, verbose bool) {
 
-	photoWaitGroup.Wait()
+	// See if a log file was provided
+	if logFileName != "" {
+		logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err == nil {
+			log.Out = logFile
+		} else {
+			log.Info("Failed to log to file, using default stderr")
+		}
+	}
+	log.WithFields(logrus.Fields{"agent": "main"})
 
+	// Set to verbose log level if turned on
+	if verbose {
+		log.SetLevel(logrus.DebugLevel)
+		log.Debug("Debug level set")
+
+	}
+}
+
+// Copy a unique photo to the output directory under its templated name
+func copyToOutput(namer *organize.Namer, photoMetadata deduplicator.DedupeFileMetadata) (string, error) {
+	destinationFileName, err := outputName(namer, photoMetadata)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destinationFileName), 0750); err != nil {
+		log.Errorf("Unable to create %s (%s)\n", filepath.Dir(destinationFileName), err.Error())
+		return "", err
+	}
+
+	if err := fileops.CopyFile(photoMetadata.Path, destinationFileName); err != nil {
+		log.Errorf("Unable to copy %s to %s (%s)\n", photoMetadata.Path, destinationFileName, err.Error())
+		return "", err
+	}
+
+	return destinationFileName, nil
+}
+
+// Templated path of a unique photo in the output directory
+func outputName(namer *organize.Namer, photoMetadata deduplicator.DedupeFileMetadata) (string, error) {
+	fields := organize.Fields{Source: photoMetadata.Path, Hash: photoMetadata.Hash}
+	if namer.NeedsCaptureTime() {
+		fields.CaptureTime = captureTime(photoMetadata)
+	}
+
+	destinationFileName, err := namer.Next(fields)
+	if err != nil {
+		log.Errorf("Unable to name the copy of %s (%s)\n", photoMetadata.Path, err.Error())
+		return "", err
+	}
+	return destinationFileName, nil
+}
+
+// Add a served photo to the report, if there is one
+func writeRecord(reporter report.Writer, photoMetadata deduplicator.DedupeFileMetadata, action string, destination string) {
+	if reporter == nil {
+		return
+	}
+	if err := reporter.Write(report.NewRecord(photoMetadata, action, destination)); err != nil {
+		log.Errorf("Unable to write %s to the report (%s)\n", photoMetadata.Path, err.Error())
+	}
+}
+
+// Report action of a duplicate replaced by a link
+func linkedAction(linkMode string) string {
+	switch linkMode {
+	case fileops.Hardlink:
+		return report.ActionHardlinked
+	case fileops.Symlink:
+		return report.ActionSymlinked
+	}
+	return report.ActionReflinked
+}
+
+// Plan an action on a served file, recording the file as it is now
+func plannedAction(actionType string, photoMetadata deduplicator.DedupeFileMetadata, destination string) plan.Action {
+	action := plan.Action{
+		Type:        actionType,
+		Source:      photoMetadata.Path,
+		Destination: destination,
+		DuplicateOf: photoMetadata.DuplicatePath,
+		Size:        photoMetadata.Size,
+		ModTime:     photoMetadata.ModTime,
+	}
+
+	// Recorded so the duplicate is left alone if the kept copy changes before the plan is applied
+	if photoMetadata.DuplicatePath != "" {
+		if info, err := os.Stat(photoMetadata.DuplicatePath); err == nil {
+			action.KeptSize = info.Size()
+			action.KeptModTime = info.ModTime()
+		}
+	}
+	return action
+}
+
+// When a photo was taken according to its EXIF data, falling back to its modification time
+func captureTime(photoMetadata deduplicator.DedupeFileMetadata) time.Time {
+	if photoMetadata.Exif != nil && !photoMetadata.Exif.CaptureTime.IsZero() {
+		return photoMetadata.Exif.CaptureTime
+	}
+	log.Debugf("No EXIF capture time for %s, using its modification time\n", photoMetadata.Path)
+	return photoMetadata.ModTime
+}
+
+// Check if child is the same as or nested inside of parent
+func isSubdirectory(parent, child string) bool {
+	absoluteParent, err := filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+	absoluteChild, err := filepath.Abs(child)
+	if err != nil {
+		return false
+	}
+
+	relativePath, err := filepath.Rel(absoluteParent, absoluteChild)
+	if err != nil {
+		return false
+	}
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/quarantine"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// Restore subcommand, puts every quarantined file from a run back where it came from
+// Usage: dedupe-agent restore [options] <manifest or run directory>
+func restore(args []string) {
+
+	var (
+		help        bool
+		verbose     bool
+		logFileName = ""
+	)
+
+	flags := getopt.New()
+	flags.SetProgram("dedupe-agent restore")
+	flags.SetParameters("<manifest or run directory>")
+	flags.FlagLong(&help, "help", 'h', "Help")
+	flags.FlagLong(&verbose, "verbose", 'v', "Verbose printing")
+	flags.FlagLong(&logFileName, "logFile", 'L', "Log file")
+
+	flags.Parse(args)
+
+	// Print help and exit if help exists
+	if help {
+		flags.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+
+	if len(flags.Args()) != 1 {
+		flags.PrintUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	initializeLogging(logFileName, verbose)
+
+	// Accept either the manifest or the run directory holding it
+	manifestPath := flags.Args()[0]
+	if info, err := os.Stat(manifestPath); err == nil && info.IsDir() {
+		manifestPath = filepath.Join(manifestPath, quarantine.ManifestName)
+	}
+
+	log.Info("Restoring from manifest: ", manifestPath)
+
+	result, err := quarantine.Restore(manifestPath)
+	if err != nil {
+		log.Errorf("Unable to restore from %s (%s)\n", manifestPath, err.Error())
+		fmt.Printf("Unable to restore from %s (%s)\n", manifestPath, err.Error())
+		os.Exit(1)
+	}
+
+	for _, entry := range result.Restored {
+		log.Debugf("Restored %s\n", entry.OriginalPath)
+	}
+
+	for _, entry := range result.Conflicts {
+		fmt.Printf("Conflict: %s already exists, quarantined copy left at %s\n", entry.OriginalPath, entry.QuarantinePath)
+	}
+
+	for _, entry := range result.Unrestorable {
+		fmt.Printf("Unrestorable: %s was permanently deleted\n", entry.OriginalPath)
+	}
+
+	for path, err := range result.Failed {
+		log.Errorf("Unable to restore %s (%s)\n", path, err.Error())
+		fmt.Printf("Failed: %s (%s)\n", path, err.Error())
+	}
+
+	fmt.Println("Restored", len(result.Restored), "files")
+
+	if len(result.Conflicts) > 0 || len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -4,8 +4,9 @@ go 1.16
 
 require (
 	github.com/aws/aws-sdk-go v1.40.12
+	github.com/cespare/xxhash/v2 v2.1.2
+	github.com/google/uuid v1.3.0
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/sirupsen/logrus v1.8.1
-	github.com/google/uuid v1.3.0
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2 // indirect
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 )
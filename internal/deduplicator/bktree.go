@@ -0,0 +1,76 @@
+package deduplicator
+
+// BK-tree over 64 bit perceptual hashes using the hamming distance.
+// Children are keyed by their distance to the parent, the triangle inequality lets a
+// query for everything within d of a hash skip every subtree outside [distance-d, distance+d],
+// so lookups only visit a small part of the tree instead of every stored hash.
+type bkTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     uint64
+	path     string
+	children map[int]*bkNode
+}
+
+// A hash found by a query along with its distance to the queried hash
+type bkMatch struct {
+	hash     uint64
+	path     string
+	distance int
+}
+
+// Add a hash to the tree
+func (tree *bkTree) add(hash uint64, path string) {
+	tree.size++
+
+	if tree.root == nil {
+		tree.root = &bkNode{hash: hash, path: path}
+		return
+	}
+
+	node := tree.root
+	for {
+		distance := hammingDistance(hash, node.hash)
+
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[distance] = &bkNode{hash: hash, path: path}
+			return
+		}
+		node = child
+	}
+}
+
+// Find every hash within maxDistance bits of hash
+func (tree *bkTree) within(hash uint64, maxDistance int) []bkMatch {
+	var matches []bkMatch
+
+	if tree.root == nil {
+		return matches
+	}
+
+	pending := []*bkNode{tree.root}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		distance := hammingDistance(hash, node.hash)
+		if distance <= maxDistance {
+			matches = append(matches, bkMatch{hash: node.hash, path: node.path, distance: distance})
+		}
+
+		for childDistance, child := range node.children {
+			if childDistance >= distance-maxDistance && childDistance <= distance+maxDistance {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	return matches
+}
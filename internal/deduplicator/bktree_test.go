@@ -0,0 +1,59 @@
+package deduplicator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBKTreeWithin(t *testing.T) {
+
+	random := rand.New(rand.NewSource(1))
+
+	var tree bkTree
+	hashes := make(map[string]uint64)
+
+	for i := 0; i < 2000; i++ {
+		hash := random.Uint64()
+		// Sprinkle in hashes close to each other
+		if i%10 == 0 {
+			hash = hashes[fmt.Sprint(i-1)] ^ (1 << uint(random.Intn(64)))
+		}
+		path := fmt.Sprint(i)
+		hashes[path] = hash
+		tree.add(hash, path)
+	}
+
+	for _, maxDistance := range []int{0, 3, 10, 20} {
+		query := random.Uint64()
+		if maxDistance == 3 {
+			query = hashes["10"]
+		}
+
+		var want []string
+		for path, hash := range hashes {
+			if hammingDistance(query, hash) <= maxDistance {
+				want = append(want, path)
+			}
+		}
+
+		var got []string
+		for _, match := range tree.within(query, maxDistance) {
+			if match.distance != hammingDistance(query, match.hash) {
+				t.Errorf("match.distance = %d; want %d", match.distance, hammingDistance(query, match.hash))
+			}
+			got = append(got, match.path)
+		}
+
+		sort.Strings(want)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("within(%d) = %v; want %v", maxDistance, got, want)
+		}
+	}
+
+	if tree.size != len(hashes) {
+		t.Errorf("tree.size = %d; want %d", tree.size, len(hashes))
+	}
+}
@@ -0,0 +1,243 @@
+package deduplicator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the hash cache file format
+const HashCacheVersion = 1
+
+// Kinds of digest kept for a file, each is stored per hash algorithm
+const (
+	cacheFull    = "full"
+	cacheContent = "content"
+)
+
+// Partial hashes also depend on how much of the file is read
+var cachePartial = "partial-" + strconv.Itoa(partialHashSize)
+
+// Digests of a file as it was when they were computed.
+// They are only reused while path, size, modification time and inode all match.
+// The device is only recorded, it changes when a NAS or USB drive is mounted again.
+type cacheEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Device  uint64 `json:"device,omitempty"`
+	Inode   uint64 `json:"inode,omitempty"`
+	// Digests by algorithm and kind ("sha256/full", "sha256/partial-4096", ...)
+	Hashes map[string]string `json:"hashes"`
+}
+
+type cacheHeader struct {
+	Version int `json:"version"`
+}
+
+// Digests computed by earlier runs, kept in a file so unchanged files are not read again.
+// Safe for use by several hashing workers at once.
+type HashCache struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	// Paths looked up by this run, always written back by Save
+	seen   map[string]bool
+	hits   int
+	misses int
+}
+
+// Open the hash cache stored at path. A missing file gives an empty cache.
+// A file that can not be parsed is logged and ignored, the cache is rebuilt on Save.
+func OpenHashCache(path string) (*HashCache, error) {
+	cache := &HashCache{
+		path:    path,
+		entries: make(map[string]*cacheEntry),
+		seen:    make(map[string]bool),
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := cache.read(file); err != nil {
+		log.Warn("Ignoring hash cache ", path, " (", err, ")")
+		cache.entries = make(map[string]*cacheEntry)
+	}
+	return cache, nil
+}
+
+func (cache *HashCache) read(file *os.File) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		// Empty file
+		return nil
+	}
+
+	header := cacheHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return err
+	}
+	if header.Version != HashCacheVersion {
+		return fmt.Errorf("unsupported version %d", header.Version)
+	}
+
+	for scanner.Scan() {
+		entry := &cacheEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return err
+		}
+		cache.entries[entry.Path] = entry
+	}
+	return scanner.Err()
+}
+
+// Look up digests in cache before hashing and store every digest computed, see OpenHashCache
+func WithHashCache(cache *HashCache) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.cache = cache
+	}
+}
+
+// Number of digests reused from the cache and computed since it was opened
+func (cache *HashCache) Stats() (hits int, misses int) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.hits, cache.misses
+}
+
+// Digest of a kind for fileName, from the cache when the file is unchanged or from compute.
+// A nil cache always computes.
+func (cache *HashCache) digest(hasher Hasher, kind string, fileName string, compute func() (string, error)) (string, error) {
+	if cache == nil {
+		return compute()
+	}
+
+	// Taken before hashing so a file changing while it is read is hashed again next time
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return "", err
+	}
+	key := hasher.Name() + "/" + kind
+	// Entries outlive the working directory of a run
+	if absolute, err := filepath.Abs(fileName); err == nil {
+		fileName = absolute
+	}
+
+	cache.lock.Lock()
+	cache.seen[fileName] = true
+	entry, ok := cache.entries[fileName]
+	if ok && entry.matches(info) {
+		if digest, ok := entry.Hashes[key]; ok {
+			cache.hits++
+			cache.lock.Unlock()
+			return digest, nil
+		}
+	}
+	cache.misses++
+	cache.lock.Unlock()
+
+	digest, err := compute()
+	if err != nil {
+		return "", err
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok = cache.entries[fileName]
+	if !ok || !entry.matches(info) {
+		entry = newCacheEntry(fileName, info)
+		cache.entries[fileName] = entry
+	}
+	entry.Hashes[key] = digest
+	return digest, nil
+}
+
+func newCacheEntry(fileName string, info os.FileInfo) *cacheEntry {
+	device, inode := fileIdentity(info)
+	return &cacheEntry{
+		Path:    fileName,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Device:  device,
+		Inode:   inode,
+		Hashes:  make(map[string]string),
+	}
+}
+
+// Check the entry still describes the file. A file found on another device is
+// the same file remounted, the entry takes the new device.
+func (entry *cacheEntry) matches(info os.FileInfo) bool {
+	device, inode := fileIdentity(info)
+	if entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() || entry.Inode != inode {
+		return false
+	}
+	if entry.Device != device {
+		log.Debug(entry.Path, " moved from device ", entry.Device, " to ", device, ", assuming it was remounted")
+		entry.Device = device
+	}
+	return true
+}
+
+// Write the cache back to its file. Entries of files that no longer exist are dropped.
+// The file is replaced in one rename so an interrupted save leaves the previous cache intact.
+func (cache *HashCache) Save() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(cache.path), "."+filepath.Base(cache.path)+".*")
+	if err != nil {
+		return err
+	}
+	// Only does something if we fail before the rename
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(cacheHeader{Version: HashCacheVersion}); err != nil {
+		file.Close()
+		return err
+	}
+
+	for path, entry := range cache.entries {
+		if !cache.seen[path] {
+			if _, err := os.Lstat(path); err != nil {
+				continue
+			}
+		}
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), cache.path)
+}
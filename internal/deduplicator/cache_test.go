@@ -0,0 +1,129 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCache(t *testing.T) {
+
+	directory := t.TempDir()
+	photos := filepath.Join(directory, "photos")
+	cachePath := filepath.Join(directory, "hashes.cache")
+	writePhotos(t, photos, map[string]string{
+		"a.jpg": "photo a",
+		"b.jpg": "photo a",
+		"c.jpg": "photo c",
+	})
+
+	run := func() (map[string]string, int, int) {
+		t.Helper()
+		cache, err := OpenHashCache(cachePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes := make(map[string]string)
+		for _, photoMetadata := range servePhotos(New(photos, 2, WithFullHashing(), WithHashCache(cache))) {
+			hashes[filepath.Base(photoMetadata.Path)] = photoMetadata.Hash
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		hits, misses := cache.Stats()
+		return hashes, hits, misses
+	}
+
+	first, hits, misses := run()
+	if hits != 0 || misses != 3 {
+		t.Errorf("first run Stats() = %d, %d; want 0 hits, 3 misses", hits, misses)
+	}
+
+	second, hits, misses := run()
+	if hits != 3 || misses != 0 {
+		t.Errorf("second run Stats() = %d, %d; want 3 hits, 0 misses", hits, misses)
+	}
+	for name, hash := range first {
+		if second[name] != hash {
+			t.Errorf("%s: cached hash = %s; want %s", name, second[name], hash)
+		}
+	}
+
+	// Mounting the photos again gives them another device number
+	cache, err := OpenHashCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range cache.entries {
+		entry.Device++
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, hits, misses = run(); hits != 3 || misses != 0 {
+		t.Errorf("remounted run Stats() = %d, %d; want 3 hits, 0 misses", hits, misses)
+	}
+
+	// Same size, new contents and modification time
+	changed := filepath.Join(photos, "c.jpg")
+	if err := os.WriteFile(changed, []byte("photo C"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(changed, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	third, hits, misses := run()
+	if hits != 2 || misses != 1 {
+		t.Errorf("third run Stats() = %d, %d; want 2 hits, 1 miss", hits, misses)
+	}
+	if third["c.jpg"] == first["c.jpg"] {
+		t.Errorf("c.jpg: hash after edit = %s; want a new hash", third["c.jpg"])
+	}
+}
+
+func TestHashCacheAlgorithms(t *testing.T) {
+
+	directory := t.TempDir()
+	photoPath := filepath.Join(directory, "a.jpg")
+	writePhotos(t, directory, map[string]string{"a.jpg": "photo a"})
+
+	cache, err := OpenHashCache(filepath.Join(directory, "hashes.cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hasher := range []Hasher{SHA256, SHA1, SHA256} {
+		digest, err := cache.digest(hasher, cacheFull, photoPath, func() (string, error) {
+			return hashPhoto(hasher, photoPath)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if DigestAlgorithm(digest) != hasher.Name() {
+			t.Errorf("digest(%s) = %s; want a %s digest", hasher.Name(), digest, hasher.Name())
+		}
+	}
+
+	if hits, misses := cache.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Stats() = %d, %d; want 1 hit, 2 misses", hits, misses)
+	}
+}
+
+func TestHashCacheCorrupt(t *testing.T) {
+
+	cachePath := filepath.Join(t.TempDir(), "hashes.cache")
+	if err := os.WriteFile(cachePath, []byte("not a cache"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := OpenHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("OpenHashCache() error = %v; want nil", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("len(entries) = %d; want 0", len(cache.entries))
+	}
+}
@@ -0,0 +1,366 @@
+package deduplicator
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Bytes read from both the start and the end of a file for a partial hash
+const partialHashSize = 4 * 1024
+
+// A file accepted as the original for its contents.
+// Hashes are only filled in once something forces them to be computed.
+type candidate struct {
+	path        string
+	size        int64
+	partialHash string
+	fullHash    string
+	// Known from a loaded index, only its full hash can be compared
+	external bool
+}
+
+// Original recorded in the photo map for its full hash
+type indexEntry struct {
+	path string
+	size int64
+	// Loaded from an index file, the path may not exist on this machine
+	external bool
+}
+
+// All originals sharing a file size. The lock is held while members are
+// compared so each file is hashed at most once.
+type sizeGroup struct {
+	lock       sync.Mutex
+	candidates []*candidate
+}
+
+// Groups files by size first, then by a partial hash of the head and tail of the
+// file, and only computes a full hash for files still colliding after that.
+// Most photos have a unique size so most files are never read.
+type candidateIndex struct {
+	lock     sync.Mutex
+	groups   map[int64]*sizeGroup
+	photoMap map[string]indexEntry
+	hasher   Hasher
+	verify   bool
+	content  bool
+	hashAll  bool
+	// Digests of earlier runs, nil when not caching
+	cache *HashCache
+}
+
+// Result of matching a file against the index
+type matchResult struct {
+	// Original with the same contents, empty when the file is unique
+	duplicateOf string
+	// Full hash of the file, empty when it never had to be computed
+	fullHash string
+	// Original with the same hash but different contents
+	collisionPath string
+	// Closest looking photo and how many perceptual hash bits it differs by
+	similarPath     string
+	similarDistance int
+}
+
+// Create a candidate index, full hashes are recorded in photoMap as they are computed.
+// With verify set, files with matching hashes are also compared byte for byte.
+// With content set only image data is hashed, see WithContentHashing.
+func newCandidateIndex(photoMap map[string]indexEntry, hasher Hasher, verify bool, content bool) *candidateIndex {
+	return &candidateIndex{
+		groups:   make(map[int64]*sizeGroup),
+		photoMap: photoMap,
+		hasher:   hasher,
+		verify:   verify,
+		content:  content,
+	}
+}
+
+// Hash every file even when its size is unique, for consumers that need the
+// hash of each file (naming output files after their hash, reports, ...)
+func WithFullHashing() Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.fullHashing = true
+	}
+}
+
+// Look for an original with the same contents as path.
+// When path is unique it becomes the original for its contents.
+func (index *candidateIndex) match(path string, size int64) (matchResult, error) {
+	return index.matchKnown(path, size, "")
+}
+
+// Same as match for a file whose full hash is already known, empty when it isn't
+func (index *candidateIndex) matchKnown(path string, size int64, fullHash string) (matchResult, error) {
+	if index.content {
+		return index.matchContent(path, size, fullHash)
+	}
+
+	photo := &candidate{path: path, size: size, fullHash: fullHash}
+	result := matchResult{}
+
+	// Hashed before taking the group lock, no need to hold up files of the same size
+	if index.hashAll {
+		if err := photo.computeFullHash(index.hasher, index.cache); err != nil {
+			return result, err
+		}
+	}
+
+	group := index.group(size)
+
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	for _, original := range group.candidates {
+		// Seeded from an earlier run and walked again
+		if original.samePath(path) {
+			continue
+		}
+
+		isMatch, err := index.compare(photo, original, size)
+		if err != nil {
+			return result, err
+		}
+
+		// Comparing may have forced a full hash of the original
+		if original.fullHash != "" {
+			index.record(original)
+		}
+
+		if !isMatch {
+			continue
+		}
+
+		if index.verify {
+			identical, err := compareFiles(path, original.path)
+			if err != nil && original.external {
+				// Only bytes that were compared make a duplicate
+				log.Warn("Unable to verify ", path, " against ", original.path, ", not treating it as a duplicate (", err, ")")
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+
+			if !identical {
+				result.collisionPath = original.path
+				continue
+			}
+		}
+
+		result.duplicateOf = original.path
+		result.fullHash = photo.fullHash
+		return result, nil
+	}
+
+	group.candidates = append(group.candidates, photo)
+	if photo.fullHash != "" && result.collisionPath == "" {
+		index.record(photo)
+	}
+
+	result.fullHash = photo.fullHash
+	return result, nil
+}
+
+// Look for an original with the same image data as path. Sizes say nothing about
+// image data so every file is hashed and looked up in the photo map directly.
+func (index *candidateIndex) matchContent(path string, size int64, fullHash string) (matchResult, error) {
+	if fullHash == "" {
+		var err error
+		fullHash, err = index.cache.digest(index.hasher, cacheContent, path, func() (string, error) {
+			return hashContent(index.hasher, path)
+		})
+		if err != nil {
+			return matchResult{}, err
+		}
+	}
+
+	result := matchResult{fullHash: fullHash}
+
+	index.lock.Lock()
+	original, ok := index.photoMap[fullHash]
+	if !ok {
+		index.photoMap[fullHash] = indexEntry{path: path, size: size}
+	}
+	index.lock.Unlock()
+
+	// Seeded from an earlier run and walked again
+	if !ok || original.samePath(path) {
+		return result, nil
+	}
+
+	if index.verify {
+		identical, err := compareContent(path, original.path)
+		if err != nil && original.external {
+			// Only image data that was compared makes a duplicate
+			log.Warn("Unable to verify ", path, " against ", original.path, ", not treating it as a duplicate (", err, ")")
+			// Later copies are verified against this file instead
+			index.lock.Lock()
+			if index.photoMap[fullHash].external {
+				index.photoMap[fullHash] = indexEntry{path: path, size: size}
+			}
+			index.lock.Unlock()
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if !identical {
+			result.collisionPath = original.path
+			return result, nil
+		}
+	}
+
+	result.duplicateOf = original.path
+	return result, nil
+}
+
+// Add an original known from an earlier run without matching it.
+// Its full hash is reused when known, otherwise hashes are computed when needed.
+// External originals must have a full hash.
+func (index *candidateIndex) seed(original *candidate) {
+	if !index.content {
+		group := index.group(original.size)
+		group.lock.Lock()
+		group.candidates = append(group.candidates, original)
+		group.lock.Unlock()
+	}
+
+	if original.fullHash != "" {
+		index.record(original)
+	}
+}
+
+// Check if path is the original itself. Index files hold absolute paths.
+func (original *candidate) samePath(path string) bool {
+	return original.path == path || (original.external && original.path == absolutePath(path))
+}
+
+func (original indexEntry) samePath(path string) bool {
+	return original.path == path || (original.external && original.path == absolutePath(path))
+}
+
+// Get the group for a size, creating it if this is the first file of that size
+func (index *candidateIndex) group(size int64) *sizeGroup {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	group, ok := index.groups[size]
+	if !ok {
+		group = &sizeGroup{}
+		index.groups[size] = group
+	}
+	return group
+}
+
+// Compare two files of the same size, computing only the hashes needed to tell them apart.
+// Must be called with the group lock held.
+func (index *candidateIndex) compare(photo, original *candidate, size int64) (bool, error) {
+	// Nothing but the hash is known about external originals
+	if original.external {
+		if err := photo.computeFullHash(index.hasher, index.cache); err != nil {
+			return false, err
+		}
+		return photo.fullHash == original.fullHash, nil
+	}
+
+	if err := photo.computePartialHash(index.hasher, index.cache, size); err != nil {
+		return false, err
+	}
+
+	if err := original.computePartialHash(index.hasher, index.cache, size); err != nil {
+		// The original can't be read anymore, nothing to compare against
+		log.Warn("Unable to hash ", original.path, " (", err, ")")
+		return false, nil
+	}
+
+	if photo.partialHash != original.partialHash {
+		return false, nil
+	}
+
+	if err := photo.computeFullHash(index.hasher, index.cache); err != nil {
+		return false, err
+	}
+
+	if err := original.computeFullHash(index.hasher, index.cache); err != nil {
+		log.Warn("Unable to hash ", original.path, " (", err, ")")
+		return false, nil
+	}
+
+	return photo.fullHash == original.fullHash, nil
+}
+
+// Record the full hash of an original in the photo map
+func (index *candidateIndex) record(original *candidate) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.photoMap[original.fullHash] = indexEntry{path: original.path, size: original.size, external: original.external}
+}
+
+// Hash the head and tail of the file
+func (photo *candidate) computePartialHash(hasher Hasher, cache *HashCache, size int64) error {
+	if photo.partialHash != "" {
+		return nil
+	}
+
+	// Small files are read completely, the partial hash is the full hash
+	if size <= 2*partialHashSize {
+		if err := photo.computeFullHash(hasher, cache); err != nil {
+			return err
+		}
+		photo.partialHash = photo.fullHash
+		return nil
+	}
+
+	partialHash, err := cache.digest(hasher, cachePartial, photo.path, func() (string, error) {
+		return hashPhotoEnds(hasher, photo.path, size)
+	})
+	if err != nil {
+		return err
+	}
+	photo.partialHash = partialHash
+	return nil
+}
+
+// Hash the whole file
+func (photo *candidate) computeFullHash(hasher Hasher, cache *HashCache) error {
+	if photo.fullHash != "" {
+		return nil
+	}
+
+	fullHash, err := cache.digest(hasher, cacheFull, photo.path, func() (string, error) {
+		return hashPhoto(hasher, photo.path)
+	})
+	if err != nil {
+		return err
+	}
+	photo.fullHash = fullHash
+	return nil
+}
+
+// Helper function to hash the first and last partialHashSize bytes of a file
+func hashPhotoEnds(hasher Hasher, fileName string, size int64) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := hasher.New()
+	if _, err := io.CopyN(h, file, partialHashSize); err != nil {
+		return "", err
+	}
+
+	if _, err := file.Seek(size-partialHashSize, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if _, err := io.CopyN(h, file, partialHashSize); err != nil {
+		return "", err
+	}
+
+	return formatDigest(hasher, h.Sum(nil)), nil
+}
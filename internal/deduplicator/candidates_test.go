@@ -0,0 +1,154 @@
+package deduplicator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchUniqueSizes(t *testing.T) {
+
+	directory := t.TempDir()
+	photos := map[string]string{
+		"a.jpg": "a",
+		"b.jpg": "bb",
+		"c.jpg": "ccc",
+	}
+	writePhotos(t, directory, photos)
+
+	photoMap := make(map[string]indexEntry)
+	index := newCandidateIndex(photoMap, SHA256, false, false)
+
+	for name, contents := range photos {
+		result, err := index.match(filepath.Join(directory, name), int64(len(contents)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.duplicateOf != "" {
+			t.Errorf("match(%s) duplicateOf = %s; want empty", name, result.duplicateOf)
+		}
+		if result.fullHash != "" {
+			t.Errorf("match(%s) fullHash = %s; want empty (not hashed)", name, result.fullHash)
+		}
+	}
+
+	if len(photoMap) != 0 {
+		t.Errorf("len(photoMap) = %d; want 0", len(photoMap))
+	}
+}
+
+func TestMatchHashAll(t *testing.T) {
+
+	directory := t.TempDir()
+	photos := map[string]string{
+		"a.jpg": "a",
+		"b.jpg": "bb",
+	}
+	writePhotos(t, directory, photos)
+
+	photoMap := make(map[string]indexEntry)
+	index := newCandidateIndex(photoMap, SHA256, false, false)
+	index.hashAll = true
+
+	for name, contents := range photos {
+		result, err := index.match(filepath.Join(directory, name), int64(len(contents)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.fullHash == "" {
+			t.Errorf("match(%s) fullHash = empty; want a hash", name)
+		}
+	}
+
+	if len(photoMap) != len(photos) {
+		t.Errorf("len(photoMap) = %d; want %d", len(photoMap), len(photos))
+	}
+}
+
+func TestMatchSameSize(t *testing.T) {
+
+	directory := t.TempDir()
+
+	// Same head and tail, only the middle differs
+	size := 4 * partialHashSize
+	original := bytes.Repeat([]byte{'x'}, size)
+	changed := bytes.Repeat([]byte{'x'}, size)
+	changed[size/2] = 'y'
+
+	files := map[string][]byte{
+		"original.jpg": original,
+		"copy.jpg":     original,
+		"changed.jpg":  changed,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), contents, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	photoMap := make(map[string]indexEntry)
+	index := newCandidateIndex(photoMap, SHA256, false, false)
+
+	originalPath := filepath.Join(directory, "original.jpg")
+	if result, err := index.match(originalPath, int64(size)); err != nil || result.duplicateOf != "" {
+		t.Fatalf("match(original.jpg) = %s, %v; want empty, nil", result.duplicateOf, err)
+	}
+
+	result, err := index.match(filepath.Join(directory, "changed.jpg"), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.duplicateOf != "" {
+		t.Errorf("match(changed.jpg) duplicateOf = %s; want empty", result.duplicateOf)
+	}
+
+	result, err = index.match(filepath.Join(directory, "copy.jpg"), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.duplicateOf != originalPath {
+		t.Errorf("match(copy.jpg) duplicateOf = %s; want %s", result.duplicateOf, originalPath)
+	}
+
+	if photoMap[result.fullHash].path != originalPath {
+		t.Errorf("photoMap[%s] = %s; want %s", result.fullHash, photoMap[result.fullHash].path, originalPath)
+	}
+}
+
+func TestMatchVerifyCollision(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"photo.jpg":    "photo",
+		"imposter.jpg": "other",
+	})
+	photoPath := filepath.Join(directory, "photo.jpg")
+	imposterPath := filepath.Join(directory, "imposter.jpg")
+
+	photoHash, err := hashPhoto(SHA256, photoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend the imposter hashed to the same value as the photo
+	index := newCandidateIndex(make(map[string]indexEntry), SHA256, true, false)
+	index.group(5).candidates = append(index.group(5).candidates, &candidate{
+		path:        imposterPath,
+		partialHash: photoHash,
+		fullHash:    photoHash,
+	})
+
+	result, err := index.match(photoPath, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.duplicateOf != "" {
+		t.Errorf("match(photo.jpg) duplicateOf = %s; want empty", result.duplicateOf)
+	}
+
+	if result.collisionPath != imposterPath {
+		t.Errorf("match(photo.jpg) collisionPath = %s; want %s", result.collisionPath, imposterPath)
+	}
+}
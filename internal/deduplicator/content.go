@@ -0,0 +1,309 @@
+package deduplicator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8, 0xFF}
+	pngSignature  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+)
+
+// JPEG markers with special handling
+const (
+	jpegMarkerStartOfImage = 0xD8
+	jpegMarkerEndOfImage   = 0xD9
+	jpegMarkerStartOfScan  = 0xDA
+	jpegMarkerComment      = 0xFE
+	jpegMarkerTemporary    = 0x01
+)
+
+// Returned when a file looks like an image but can not be parsed
+type contentError struct {
+	fileName string
+	err      error
+}
+
+func (err *contentError) Error() string {
+	return fmt.Sprintf("unable to read image data of %s: %s", err.fileName, err.err)
+}
+
+func (err *contentError) Unwrap() error {
+	return err.err
+}
+
+// Hash only the pixel data of photos so metadata edits (EXIF rotation, stripped GPS,
+// added captions) don't change the hash. JPEGs are hashed without their APPn and COM
+// segments, PNGs by the chunks describing their pixels (IHDR, PLTE, tRNS and IDAT) only.
+// Any other file is hashed as is.
+// File sizes differ between such copies so every file is fully hashed in this mode.
+func WithContentHashing() Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.contentHashing = true
+	}
+}
+
+// Hash the image data of a file. Files which can't be parsed are hashed as is.
+func hashContent(hasher Hasher, fileName string) (string, error) {
+	content, err := openContent(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	h := hasher.New()
+	_, err = io.Copy(h, content)
+
+	var parseErr *contentError
+	if errors.As(err, &parseErr) {
+		log.Debug(parseErr, ", hashing the whole file")
+		return hashPhoto(hasher, fileName)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return formatDigest(hasher, h.Sum(nil)), nil
+}
+
+// Compare the image data of two files. Falls back to comparing the whole
+// files when either one can't be parsed.
+func compareContent(firstName, secondName string) (bool, error) {
+	first, err := openContent(firstName)
+	if err != nil {
+		return false, err
+	}
+	defer first.Close()
+
+	second, err := openContent(secondName)
+	if err != nil {
+		return false, err
+	}
+	defer second.Close()
+
+	identical, err := compareReaders(first, second)
+
+	var parseErr *contentError
+	if errors.As(err, &parseErr) {
+		log.Debug(parseErr, ", comparing the whole files")
+		return compareFiles(firstName, secondName)
+	}
+
+	return identical, err
+}
+
+// Open the image data of a file. JPEG and PNG files are streamed through a parser
+// which drops their metadata, other files are returned unchanged.
+// Parse failures are returned from Read as a *contentError.
+func openContent(fileName string) (io.ReadCloser, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	signature, _ := reader.Peek(len(pngSignature))
+
+	var extract func(io.Writer, *bufio.Reader) error
+	switch {
+	case bytes.HasPrefix(signature, jpegSignature):
+		extract = extractJPEG
+	case bytes.HasPrefix(signature, pngSignature):
+		extract = extractPNG
+	default:
+		// The signature was already buffered, keep reading through the buffer
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+
+	contentReader, contentWriter := io.Pipe()
+	go func() {
+		defer file.Close()
+		if err := extract(contentWriter, reader); err != nil {
+			contentWriter.CloseWithError(&contentError{fileName: fileName, err: err})
+			return
+		}
+		contentWriter.Close()
+	}()
+
+	return contentReader, nil
+}
+
+// Write every JPEG segment except APPn and COM, along with the entropy coded scan data
+func extractJPEG(output io.Writer, input *bufio.Reader) error {
+	writer := bufio.NewWriter(output)
+
+	marker, err := readJPEGMarker(input)
+	if err != nil {
+		return err
+	}
+	if marker != jpegMarkerStartOfImage {
+		return errors.New("missing start of image")
+	}
+
+	// Set when a scan ends on a marker that still has to be handled
+	var nextMarker byte
+
+	for {
+		if nextMarker != 0 {
+			marker, nextMarker = nextMarker, 0
+		} else if marker, err = readJPEGMarker(input); err != nil {
+			return err
+		}
+
+		if marker == jpegMarkerEndOfImage {
+			return writer.Flush()
+		}
+
+		// Markers without a payload
+		if marker == jpegMarkerTemporary || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(input, lengthBytes[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint16(lengthBytes[:]))
+		if length < 2 {
+			return fmt.Errorf("invalid segment length %d", length)
+		}
+
+		// Metadata segments are skipped
+		if (marker >= 0xE0 && marker <= 0xEF) || marker == jpegMarkerComment {
+			if _, err := input.Discard(int(length - 2)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		writer.Write([]byte{0xFF, marker})
+		writer.Write(lengthBytes[:])
+		if _, err := io.CopyN(writer, input, length-2); err != nil {
+			return err
+		}
+
+		if marker != jpegMarkerStartOfScan {
+			continue
+		}
+
+		// Entropy coded data runs until the next marker that isn't a stuffed byte or a restart
+		nextMarker, err = copyJPEGScan(writer, input)
+		if err == io.EOF {
+			// Truncated in the scan, keep what we have
+			return writer.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Copy entropy coded data up to the next marker, which is consumed and returned
+func copyJPEGScan(writer *bufio.Writer, input *bufio.Reader) (byte, error) {
+	for {
+		value, err := input.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if value != 0xFF {
+			// Stop early when nobody is reading anymore
+			if err := writer.WriteByte(value); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		next, err := input.ReadByte()
+		// Fill bytes before a marker
+		for err == nil && next == 0xFF {
+			next, err = input.ReadByte()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// Stuffed 0xFF or a restart marker, both belong to the scan
+		if next == 0x00 || (next >= 0xD0 && next <= 0xD7) {
+			writer.WriteByte(value)
+			writer.WriteByte(next)
+			continue
+		}
+
+		return next, nil
+	}
+}
+
+// Read a marker, skipping any fill bytes
+func readJPEGMarker(input *bufio.Reader) (byte, error) {
+	value, err := input.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if value != 0xFF {
+		return 0, fmt.Errorf("expected marker, found 0x%02X", value)
+	}
+
+	for value == 0xFF {
+		if value, err = input.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return value, nil
+}
+
+// PNG chunks written before the image data, they change what the pixels look like
+var pngImageChunks = map[string]bool{"IHDR": true, "PLTE": true, "tRNS": true}
+
+// Write the chunks needed to draw the image: the header, palette and transparency chunks
+// with their type and length, then the data of every IDAT chunk. IDAT data is written
+// alone since encoders split it into chunks differently.
+func extractPNG(output io.Writer, input *bufio.Reader) error {
+	if _, err := input.Discard(len(pngSignature)); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(input, header[:]); err != nil {
+			return err
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		if pngImageChunks[chunkType] {
+			if _, err := output.Write(header[:]); err != nil {
+				return err
+			}
+		}
+
+		if chunkType == "IDAT" || pngImageChunks[chunkType] {
+			if _, err := io.CopyN(output, input, length); err != nil {
+				return err
+			}
+		} else if _, err := input.Discard(int(length)); err != nil {
+			return err
+		}
+
+		// CRC
+		if _, err := input.Discard(4); err != nil {
+			return err
+		}
+
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package deduplicator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Insert a metadata segment right after the JPEG start of image marker
+func withJPEGSegment(t *testing.T, photo []byte, marker byte, payload []byte) []byte {
+	t.Helper()
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	edited := append([]byte(nil), photo[:2]...)
+	edited = append(edited, segment...)
+	return append(edited, photo[2:]...)
+}
+
+// Insert a chunk right before the PNG IEND chunk
+func withPNGChunk(t *testing.T, photo []byte, chunkType string, data []byte) []byte {
+	t.Helper()
+	chunk := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	end := len(photo) - 12
+	edited := append([]byte(nil), photo[:end]...)
+	edited = append(edited, chunk...)
+	return append(edited, photo[end:]...)
+}
+
+func TestHashContent(t *testing.T) {
+
+	var encodedJPEG, encodedPNG, otherJPEG bytes.Buffer
+	if err := jpeg.Encode(&encodedJPEG, testPhoto(64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&otherJPEG, testOtherPhoto(64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&encodedPNG, testPhoto(64, 48)); err != nil {
+		t.Fatal(err)
+	}
+
+	exif := withJPEGSegment(t, encodedJPEG.Bytes(), 0xE1, []byte("Exif\x00\x00 rotated 90 degrees"))
+	captioned := withJPEGSegment(t, exif, 0xFE, []byte("a caption"))
+	annotated := withPNGChunk(t, encodedPNG.Bytes(), "tEXt", []byte("Comment\x00edited"))
+
+	directory := t.TempDir()
+	files := map[string][]byte{
+		"original.jpg":  encodedJPEG.Bytes(),
+		"exif.jpg":      exif,
+		"captioned.jpg": captioned,
+		"other.jpg":     otherJPEG.Bytes(),
+		"original.png":  encodedPNG.Bytes(),
+		"annotated.png": annotated,
+		"notes.txt":     []byte("not a photo"),
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), contents, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digests := make(map[string]string)
+	for name := range files {
+		digest, err := hashContent(SHA256, filepath.Join(directory, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[name] = digest
+	}
+
+	for _, name := range []string{"exif.jpg", "captioned.jpg"} {
+		if digests[name] != digests["original.jpg"] {
+			t.Errorf("hashContent(%s) = %s; want %s", name, digests[name], digests["original.jpg"])
+		}
+	}
+
+	if digests["annotated.png"] != digests["original.png"] {
+		t.Errorf("hashContent(annotated.png) = %s; want %s", digests["annotated.png"], digests["original.png"])
+	}
+
+	if digests["other.jpg"] == digests["original.jpg"] {
+		t.Errorf("hashContent(other.jpg) = hashContent(original.jpg); want different")
+	}
+
+	rawDigest, err := hashPhoto(SHA256, filepath.Join(directory, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digests["notes.txt"] != rawDigest {
+		t.Errorf("hashContent(notes.txt) = %s; want %s", digests["notes.txt"], rawDigest)
+	}
+
+	identical, err := compareContent(filepath.Join(directory, "original.jpg"), filepath.Join(directory, "captioned.jpg"))
+	if err != nil || !identical {
+		t.Errorf("compareContent(original.jpg, captioned.jpg) = %t, %v; want true, nil", identical, err)
+	}
+}
+
+func TestHashContentPalette(t *testing.T) {
+
+	// Same palette indices, only the colors they stand for differ
+	indices := image.NewPaletted(image.Rect(0, 0, 16, 16), nil)
+	for i := range indices.Pix {
+		indices.Pix[i] = uint8(i % 2)
+	}
+	palettes := map[string]color.Palette{
+		"black-white.png": {color.Black, color.White},
+		"red-blue.png":    {color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}},
+	}
+
+	directory := t.TempDir()
+	digests := make(map[string]string)
+	for name, palette := range palettes {
+		indices.Palette = palette
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, indices); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(directory, name)
+		if err := os.WriteFile(path, encoded.Bytes(), 0640); err != nil {
+			t.Fatal(err)
+		}
+		digest, err := hashContent(SHA256, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests[name] = digest
+	}
+
+	if digests["black-white.png"] == digests["red-blue.png"] {
+		t.Errorf("hashContent() of PNGs with different palettes = %s for both; want different", digests["red-blue.png"])
+	}
+
+	identical, err := compareContent(filepath.Join(directory, "black-white.png"), filepath.Join(directory, "red-blue.png"))
+	if err != nil || identical {
+		t.Errorf("compareContent() of PNGs with different palettes = %t, %v; want false, nil", identical, err)
+	}
+}
+
+func TestServeContentHashing(t *testing.T) {
+
+	var encodedJPEG bytes.Buffer
+	if err := jpeg.Encode(&encodedJPEG, testPhoto(64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "original.jpg"), encodedJPEG.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	}
+	edited := withJPEGSegment(t, encodedJPEG.Bytes(), 0xE1, []byte("Exif\x00\x00GPS stripped"))
+	if err := os.WriteFile(filepath.Join(directory, "edited.jpg"), edited, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates := 0
+	deduplicator := New(directory, 2, WithContentHashing())
+	deduplicator.SetVerify(true)
+	for _, photoMetadata := range servePhotos(deduplicator) {
+		if photoMetadata.Status == StatusDuplicate {
+			duplicates++
+		}
+	}
+
+	if duplicates != 1 {
+		t.Errorf("duplicates = %d; want 1", duplicates)
+	}
+}
@@ -1,51 +1,163 @@
 package deduplicator
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"photo-deduplicator/internal/exif"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type PhotoDeduplicator struct {
 	directory       string
-	photoMap        map[string]string
+	roots           []string
+	references      []string
+	photoMap        map[string]indexEntry
 	hashingRoutines int
 	bufferSize      int
+	verify          bool
+	hasher          Hasher
+	contentHashing  bool
+	fullHashing     bool
+	readExif        bool
+	cache           *HashCache
+	filter          *Filter
+	skipped         *skipCounter
+
+	perceptual          PerceptualAlgorithm
+	perceptualThreshold int
+
+	keeperPolicies []KeeperPolicy
+	groups         *groupIndex
+
+	// Set by WithIncremental, incremental is nil when the scan covers everything
+	previous    *Snapshot
+	incremental *incrementalScan
+	snapshot    *snapshotIndex
+}
+
+// Configures a PhotoDeduplicator when passed to New
+type Option func(*PhotoDeduplicator)
+
+// Hash photos with the given algorithm instead of SHA-256
+func WithHasher(hasher Hasher) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.hasher = hasher
+	}
+}
+
+// Outcome of deduplicating a single file
+type DedupeStatus int
+
+const (
+	// No other file has the same contents
+	StatusUnique DedupeStatus = iota
+	// Same contents as DuplicatePath
+	StatusDuplicate
+	// Same hash as CollisionPath but the contents differ, only detected with verification on
+	StatusHashCollision
+	// Looks like SimilarPath but the contents differ, only detected with perceptual hashing on
+	StatusNearDuplicate
+)
+
+// Name of the status, used for logging and reports
+func (status DedupeStatus) String() string {
+	switch status {
+	case StatusUnique:
+		return "unique"
+	case StatusDuplicate:
+		return "duplicate"
+	case StatusHashCollision:
+		return "hash-collision"
+	case StatusNearDuplicate:
+		return "near-duplicate"
+	}
+	return "unknown"
 }
 
 type DedupeFileMetadata struct {
 	Path          string
 	DuplicatePath string
+	CollisionPath string
+	Status        DedupeStatus
+	// Digest of the file prefixed by its algorithm ("sha256:..."), empty when the
+	// file never had to be hashed
+	Hash string
+	// Closest looking photo for near duplicates
+	SimilarPath string
+	// How alike the photo is to DuplicatePath or SimilarPath, 1 for exact duplicates
+	// down to 0 for nothing alike
+	Similarity float64
+	// Size and modification time of the file, zero when it could not be read
+	Size    int64
+	ModTime time.Time
+	// Metadata parsed from the photo, nil unless WithExif is set and something could be read
+	Exif *exif.Metadata
+	// Files with the same contents share a group id, see Groups
+	GroupID int
+	// Root directory the file was found in, see WithRoots
+	Root string
 }
 
-// Holds key value pairs
+// Holds a photo hash (key) and the file name (val) along with how it matched
+// and what is known about the file.
+// The hash is empty when the photo never had to be hashed.
 type pair struct {
 	key, val string
+	root     string
+	match    matchResult
+	size     int64
+	modTime  time.Time
+	exif     *exif.Metadata
+
+	// Position of the file in the walk, skip is set when there is nothing to serve
+	seq  int
+	skip bool
+}
+
+// A walked file and its position in the walk
+type walkedFile struct {
+	path string
+	seq  int
 }
 
 // Create a new photo deduplicator
-func New(directory string, hashingRoutines int) *PhotoDeduplicator {
+func New(directory string, hashingRoutines int, options ...Option) *PhotoDeduplicator {
 
-	return &PhotoDeduplicator{
+	deduplicator := &PhotoDeduplicator{
 		directory:       directory,
-		photoMap:        make(map[string]string),
+		photoMap:        make(map[string]indexEntry),
 		hashingRoutines: hashingRoutines,
 		bufferSize:      10,
+		hasher:          SHA256,
+		skipped:         newSkipCounter(),
+		groups:          newGroupIndex(),
+		snapshot:        newSnapshotIndex(),
 	}
+
+	for _, option := range options {
+		option(deduplicator)
+	}
+
+	return deduplicator
 }
 
 // Run the deduplication
 // a channel is passed to the function which will serve details about the photos being processed
 // waitgroup will notify when all photos have been processed
-func (deduplicator *PhotoDeduplicator) Serve(dedupedPhotoChannel chan<- DedupeFileMetadata, dedupedPhotoWaitGroup *sync.WaitGroup) {
+// Photos are served in the order they were walked so every run serves the same input in the
+// same order, a file is held back until every file walked before it was served.
+// When ctx is cancelled walking stops, files already being hashed are finished and served, and the
+// channel is closed. With a keeper policy nothing more is served once cancelled.
+func (deduplicator *PhotoDeduplicator) Serve(ctx context.Context, dedupedPhotoChannel chan<- DedupeFileMetadata, dedupedPhotoWaitGroup *sync.WaitGroup) {
 
 	// Spawn go routine to start dedupliaction
-	go deduplicator.serveHandler(dedupedPhotoChannel, dedupedPhotoWaitGroup)
+	go deduplicator.serveHandler(ctx, dedupedPhotoChannel, dedupedPhotoWaitGroup)
 }
 
 // Set the size of the internal buffers for the deduplicator
@@ -54,15 +166,20 @@ func (deduplicator *PhotoDeduplicator) SetBufferSize(bufferSize int) {
 	deduplicator.bufferSize = bufferSize
 }
 
-// Go routine which is going to run the deduplicator in a non blocking way.
-func (deduplicator *PhotoDeduplicator) serveHandler(dedupedPhotoChannel chan<- DedupeFileMetadata, dedupedPhotoWaitGroup *sync.WaitGroup) {
-	photoList, err := getPhotos(deduplicator.directory)
+// Compare files byte for byte before reporting them as duplicates.
+// Files with matching hashes but different contents are served as StatusHashCollision.
+func (deduplicator *PhotoDeduplicator) SetVerify(verify bool) {
+	deduplicator.verify = verify
+}
 
-	if err != nil {
-		log.Fatal("Error getting photos list")
-		panic(err)
-	}
+// Number of files kept out of the pipeline by the filter, keyed by reason (SkipNotMedia, ...)
+// Complete once all photos have been served.
+func (deduplicator *PhotoDeduplicator) SkippedFiles() map[string]int {
+	return deduplicator.skipped.snapshot()
+}
 
+// Go routine which is going to run the deduplicator in a non blocking way.
+func (deduplicator *PhotoDeduplicator) serveHandler(ctx context.Context, dedupedPhotoChannel chan<- DedupeFileMetadata, dedupedPhotoWaitGroup *sync.WaitGroup) {
 	// Channel file names are pushed onto this channel
 	photoChannel := make(chan string, deduplicator.bufferSize)
 	// Wait group to verify all photos have been collected
@@ -79,18 +196,84 @@ func (deduplicator *PhotoDeduplicator) serveHandler(dedupedPhotoChannel chan<- D
 	// processed
 	dedupedPhotoWaitGroup.Add(1)
 
+	// Groups and snapshots only describe the latest scan
+	deduplicator.groups = newGroupIndex()
+	deduplicator.snapshot = newSnapshotIndex()
+
+	// With several roots copies in earlier roots are kept first
+	roots := deduplicator.Roots()
+	policies := deduplicator.keeperPolicies
+	if len(roots) > 1 {
+		policies = append([]KeeperPolicy{keepInRootOrder(roots)}, policies...)
+	}
+
+	// Files are grouped by size before anything is hashed
+	index := newCandidateIndex(deduplicator.photoMap, deduplicator.hasher, deduplicator.verify, deduplicator.contentHashing)
+	index.hashAll = deduplicator.fullHashing
+	index.cache = deduplicator.cache
+	similarIndex := &perceptualIndex{}
+
+	// Originals loaded by LoadIndex
+	deduplicator.seedExternal(index)
+
+	// Unchanged files of the previous run are matched against but never walked again
+	deduplicator.incremental = nil
+	if deduplicator.previous != nil {
+		deduplicator.incremental = newIncrementalScan(deduplicator.previous, deduplicator, index, deduplicator.snapshot)
+	}
+
+	// Reference files are all known before the first other file is matched
+	allRoots := append(append([]string{}, deduplicator.references...), roots...)
+	if len(deduplicator.references) > 0 {
+		deduplicator.matchReferences(ctx, allRoots, index, similarIndex)
+	}
+
+	// Served files are grouped and recorded in the snapshot
+	served := func(photoMetadata *DedupeFileMetadata) {
+		deduplicator.groups.add(photoMetadata)
+		deduplicator.snapshot.served(photoMetadata)
+	}
+
 	// Spawn some go routines to do the hashing
+	fileChannel := make(chan walkedFile, deduplicator.bufferSize)
+	go numberPhotos(photoChannel, fileChannel)
 	for i := 0; i < deduplicator.hashingRoutines; i++ {
-		go processPhoto(i, photoChannel, keyValueChannel, &photoWaitGroup)
+		go deduplicator.processPhoto(ctx, i, roots, index, similarIndex, fileChannel, keyValueChannel, &photoWaitGroup)
 	}
 
-	// Spawn the go routine to store the hashes
-	go checkCollision(keyValueChannel, dedupedPhotoChannel, &hashingWaitGroup, &deduplicator.photoMap)
+	// With a keeper policy nothing is served until every duplicate group is complete
+	collisionChannel := dedupedPhotoChannel
+	var buffered []DedupeFileMetadata
+	var bufferWaitGroup sync.WaitGroup
+	record := served
+	if len(policies) > 0 {
+		// Recorded once the keepers are known
+		record = nil
+		bufferChannel := make(chan DedupeFileMetadata, deduplicator.bufferSize)
+		collisionChannel = bufferChannel
+		bufferWaitGroup.Add(1)
+		go func() {
+			for photoMetadata := range bufferChannel {
+				buffered = append(buffered, photoMetadata)
+			}
+			bufferWaitGroup.Done()
+		}()
+	}
+
+	// Spawn the go routine to report collisions
+	go checkCollision(keyValueChannel, collisionChannel, record, &hashingWaitGroup)
 
-	// Iterate through all the photos
+	// Walk every root, photos are hashed as soon as they are found
 	log.Info("Iterate through photos")
-	for _, photo := range photoList {
-		photoChannel <- photo
+	for _, root := range roots {
+		if err := walkRoot(ctx, root, nestedRoots(allRoots, root), deduplicator.filter, deduplicator.skipped, photoChannel); err != nil {
+			if ctx.Err() != nil {
+				log.Info("Cancelled, stopping the walk")
+				break
+			}
+			log.Error("Error walking photos in ", root, ", stopping early")
+			log.Error(err)
+		}
 	}
 	close(photoChannel)
 	log.Info("Photo channel closed")
@@ -102,20 +285,92 @@ func (deduplicator *PhotoDeduplicator) serveHandler(dedupedPhotoChannel chan<- D
 	// Wait for all the hashing
 	hashingWaitGroup.Wait()
 
+	if len(policies) > 0 {
+		close(collisionChannel)
+		bufferWaitGroup.Wait()
+		if ctx.Err() != nil {
+			// Groups are incomplete, keepers picked from them could be wrong
+			log.Info("Cancelled, dropping ", len(buffered), " photos waiting for their keepers")
+			buffered = nil
+		}
+		for _, photoMetadata := range selectKeepers(buffered, policies) {
+			served(&photoMetadata)
+			dedupedPhotoChannel <- photoMetadata
+		}
+	}
+
 	// Close the output channel
 	close(dedupedPhotoChannel)
 	// Close the final waitgroup to signal all photos have been processed
 	dedupedPhotoWaitGroup.Done()
 }
 
-// Receives a photo hashes it, and places it on a channel for further actions
-func processPhoto(routineId int, inputChannel chan string, outputChannel chan pair, photoWaitGroup *sync.WaitGroup) {
+// Receives a photo, matches it against the candidate index and places it on a channel for further actions
+// Only photos sharing a size with another photo are hashed. Photos which are not exact duplicates are
+// checked against the perceptual index when perceptual hashing is on.
+// Once ctx is cancelled remaining names are drained without being looked at.
+// A pair is sent for every file, with skip set for files that are not served.
+func (deduplicator *PhotoDeduplicator) processPhoto(ctx context.Context, routineId int, roots []string, index *candidateIndex, similarIndex *perceptualIndex, inputChannel chan walkedFile, outputChannel chan pair, photoWaitGroup *sync.WaitGroup) {
 	log.Info("Starting Go Routine ", routineId)
-	for fileName := range inputChannel {
+	for walked := range inputChannel {
+		fileName := walked.path
+		skipped := pair{seq: walked.seq, skip: true}
+
+		if ctx.Err() != nil {
+			outputChannel <- skipped
+			continue
+		}
+
+		// Names were already filtered by the walker, contents are checked here so the walk isn't slowed by I/O
+		if deduplicator.filter != nil {
+			if reason := deduplicator.filter.checkContent(fileName); reason != "" {
+				deduplicator.skipped.add(fileName, reason)
+				outputChannel <- skipped
+				continue
+			}
+		}
+
+		var keyValue pair = pair{val: fileName, root: rootOf(roots, fileName), seq: walked.seq}
 
-		hashedValue := hashPhoto(fileName)
+		info, err := os.Stat(fileName)
+		if err != nil {
+			log.Error("Issue reading ", fileName)
+			log.Error(err)
+			outputChannel <- keyValue
+			continue
+		}
 
-		var keyValue pair = pair{hashedValue, fileName}
+		// Unchanged since the previous run, moved files keep their hash
+		knownHash := ""
+		if deduplicator.incremental != nil {
+			hash, changed := deduplicator.incremental.classify(fileName, info)
+			if !changed {
+				outputChannel <- skipped
+				continue
+			}
+			knownHash = hash
+		}
+		deduplicator.snapshot.observe(fileName, info)
+
+		keyValue.size = info.Size()
+		keyValue.modTime = info.ModTime()
+		if deduplicator.readExif {
+			keyValue.exif = readMetadata(fileName)
+		}
+
+		result, err := index.matchKnown(fileName, info.Size(), knownHash)
+		if err != nil {
+			// Can't tell if it is a duplicate, treat it as unique
+			log.Error("Issue hashing ", fileName)
+			log.Error(err)
+		}
+
+		if deduplicator.perceptual != PerceptualNone && result.duplicateOf == "" && err == nil {
+			deduplicator.matchSimilar(similarIndex, fileName, &result)
+		}
+
+		keyValue.key = result.fullHash
+		keyValue.match = result
 
 		outputChannel <- keyValue
 
@@ -125,75 +380,162 @@ func processPhoto(routineId int, inputChannel chan string, outputChannel chan pa
 	return
 }
 
-// Read pairs off of a channel, add them to the map if they don't already exist
+// Look for a photo that looks like fileName, recording it in the match result.
+// Files that can not be decoded as images are skipped.
+func (deduplicator *PhotoDeduplicator) matchSimilar(similarIndex *perceptualIndex, fileName string, result *matchResult) {
+	hash, err := perceptualHashPhoto(deduplicator.perceptual, fileName)
+	if err != nil {
+		log.Debug("Not perceptually hashing ", fileName, " (", err, ")")
+		return
+	}
+
+	similarPath, distance, found := similarIndex.matchOrAdd(hash, fileName, deduplicator.perceptualThreshold)
+	if found {
+		result.similarPath = similarPath
+		result.similarDistance = distance
+	}
+}
+
+// Read pairs off of a channel and serve them in the order they were walked
 // Identify when a collision has occured
-func checkCollision(inputChannel chan pair, outputChannel chan<- DedupeFileMetadata, hashingWaitGroup *sync.WaitGroup, photoMap *map[string]string) {
+// Served files are passed to record first, unless it is nil
+func checkCollision(inputChannel chan pair, outputChannel chan<- DedupeFileMetadata, record func(*DedupeFileMetadata), hashingWaitGroup *sync.WaitGroup) {
+	// Pairs held back until the files walked before them are done hashing
+	waiting := make(map[int]pair)
+	next := 0
 	for keyValuePair := range inputChannel {
-
-		fileMetadata := DedupeFileMetadata{
-			Path:          keyValuePair.val,
-			DuplicatePath: "",
+		waiting[keyValuePair.seq] = keyValuePair
+		for {
+			ready, ok := waiting[next]
+			if !ok {
+				break
+			}
+			delete(waiting, next)
+			next++
+			if !ready.skip {
+				servePair(ready, outputChannel, record)
+			}
 		}
+	}
 
-		collidedFile := (*photoMap)[keyValuePair.key]
+	hashingWaitGroup.Done()
+	return
+}
 
-		if collidedFile == "" {
-			// Write to map
-			(*photoMap)[keyValuePair.key] = keyValuePair.val
-		} else {
-			log.Info("Collision: ", keyValuePair.val, " == ", collidedFile)
+// Serve a single pair
+func servePair(keyValuePair pair, outputChannel chan<- DedupeFileMetadata, record func(*DedupeFileMetadata)) {
+	fileMetadata := DedupeFileMetadata{
+		Path:          keyValuePair.val,
+		Root:          keyValuePair.root,
+		DuplicatePath: keyValuePair.match.duplicateOf,
+		CollisionPath: keyValuePair.match.collisionPath,
+		SimilarPath:   keyValuePair.match.similarPath,
+		Status:        StatusUnique,
+		Hash:          keyValuePair.key,
+		Size:          keyValuePair.size,
+		ModTime:       keyValuePair.modTime,
+		Exif:          keyValuePair.exif,
+	}
 
-			// Mark as duplicate
-			fileMetadata.DuplicatePath = collidedFile
-		}
+	if fileMetadata.DuplicatePath != "" {
+		log.Info("Collision: ", keyValuePair.val, " == ", fileMetadata.DuplicatePath)
+		fileMetadata.Status = StatusDuplicate
+		fileMetadata.Similarity = 1
+	} else if fileMetadata.CollisionPath != "" {
+		log.Warn("Hash collision: ", keyValuePair.val, " != ", fileMetadata.CollisionPath)
+		fileMetadata.Status = StatusHashCollision
+	} else if fileMetadata.SimilarPath != "" {
+		log.Info("Near duplicate: ", keyValuePair.val, " ~= ", fileMetadata.SimilarPath)
+		fileMetadata.Status = StatusNearDuplicate
+		fileMetadata.Similarity = similarity(keyValuePair.match.similarDistance)
+	}
 
-		outputChannel <- fileMetadata
+	if record != nil {
+		record(&fileMetadata)
 	}
 
-	hashingWaitGroup.Done()
-	return
+	outputChannel <- fileMetadata
+}
+
+// Number walked files so their results can be served in the order they were walked
+func numberPhotos(photoChannel <-chan string, fileChannel chan<- walkedFile) {
+	seq := 0
+	for fileName := range photoChannel {
+		fileChannel <- walkedFile{path: fileName, seq: seq}
+		seq++
+	}
+	close(fileChannel)
 }
 
-func getPhotos(directory string) ([]string, error) {
-	var photos []string
+func walkPhotos(directory string, filter *Filter, skipped *skipCounter, photoChannel chan<- string) error {
+	return walkRoot(context.Background(), directory, nil, filter, skipped, photoChannel)
+}
+
+// Same as walkPhotos, leaving out the nested directories (absolute paths) which are walked on their own.
+// Stops with the context's error once it is cancelled.
+func walkRoot(ctx context.Context, directory string, nested map[string]bool, filter *Filter, skipped *skipCounter, photoChannel chan<- string) error {
+	return filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		// Check errors
 		if err != nil {
-			return err
+			if path == directory {
+				return err
+			}
+			log.Warn("Skipping ", path, " (", err, ")")
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
 		// Not going to include directories
-		if info.IsDir() {
+		if entry.IsDir() {
+			if len(nested) > 0 && path != directory && nested[absolutePath(path)] {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		// TODO: Weed out file types
-		photos = append(photos, path)
-		return nil
-	})
+		if filter != nil {
+			// Only regular files, no links, devices or sockets
+			if !entry.Type().IsRegular() {
+				skipped.add(path, SkipNotRegular)
+				return nil
+			}
+
+			if reason := filter.checkName(directory, path); reason != "" {
+				skipped.add(path, reason)
+				return nil
+			}
+		}
 
-	return photos, err
+		select {
+		case photoChannel <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
 }
 
-// Helper function to hash a file, return hased value
-func hashPhoto(fileName string) string {
+// Helper function to hash a file, return hashed value
+func hashPhoto(hasher Hasher, fileName string) (string, error) {
 
 	file, err := os.Open(fileName)
 	if err != nil {
-		log.Error("Issue opening ", fileName)
-		log.Error(err)
+		return "", err
 	}
+	// Close the file, not needed after hashing
+	defer file.Close()
 
 	// Hash file
-	h := sha256.New()
+	h := hasher.New()
 	if _, err := io.Copy(h, file); err != nil {
-		log.Error("Issue copying file ", fileName)
-		log.Error(err)
+		return "", err
 	}
 	// Turn the hash into a string
-	sha := base64.URLEncoding.EncodeToString(h.Sum(nil))
-	// Close the file, not needed anymore
-	file.Close()
-	return sha
+	return formatDigest(hasher, h.Sum(nil)), nil
 }
@@ -1,7 +1,12 @@
 package deduplicator
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -39,3 +44,160 @@ func TestAddToMap(t *testing.T) {
 	// TODO: Finish up this test
 
 }
+
+// Write files into a directory, keys are paths relative to the directory
+func writePhotos(t *testing.T, directory string, photos map[string]string) {
+	t.Helper()
+	for name, contents := range photos {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Run a deduplicator to completion and collect everything it served
+func servePhotos(deduplicator *PhotoDeduplicator) []DedupeFileMetadata {
+	photoChannel := make(chan DedupeFileMetadata)
+	var photoWaitGroup sync.WaitGroup
+
+	deduplicator.Serve(context.Background(), photoChannel, &photoWaitGroup)
+
+	var served []DedupeFileMetadata
+	for photoMetadata := range photoChannel {
+		served = append(served, photoMetadata)
+	}
+	photoWaitGroup.Wait()
+	return served
+}
+
+func TestWalkPhotos(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a.jpg":        "a",
+		"nested/b.jpg": "b",
+		"nested/c.jpg": "c",
+	})
+
+	photoChannel := make(chan string)
+	var walkErr error
+	go func() {
+		walkErr = walkPhotos(directory, nil, newSkipCounter(), photoChannel)
+		close(photoChannel)
+	}()
+
+	var walked []string
+	for photo := range photoChannel {
+		walked = append(walked, photo)
+	}
+
+	if walkErr != nil {
+		t.Fatal(walkErr)
+	}
+
+	if len(walked) != 3 {
+		t.Errorf("len(walked) = %d; want 3", len(walked))
+	}
+
+	if err := walkPhotos(filepath.Join(directory, "missing"), nil, newSkipCounter(), photoChannel); err == nil {
+		t.Errorf("walkPhotos(missing) error = nil; want error")
+	}
+}
+
+func TestServe(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a.jpg":           "same photo",
+		"copy/a-copy.jpg": "same photo",
+		"b.jpg":           "different photo",
+	})
+
+	served := servePhotos(New(directory, 2))
+
+	if len(served) != 3 {
+		t.Fatalf("len(served) = %d; want 3", len(served))
+	}
+
+	duplicates := 0
+	for _, photoMetadata := range served {
+		if photoMetadata.DuplicatePath == "" {
+			continue
+		}
+		duplicates++
+
+		pair := []string{filepath.Base(photoMetadata.Path), filepath.Base(photoMetadata.DuplicatePath)}
+		sort.Strings(pair)
+		if pair[0] != "a-copy.jpg" || pair[1] != "a.jpg" {
+			t.Errorf("duplicate pair = %v; want [a-copy.jpg a.jpg]", pair)
+		}
+	}
+
+	if duplicates != 1 {
+		t.Errorf("duplicates = %d; want 1", duplicates)
+	}
+}
+
+func TestServeWalkOrder(t *testing.T) {
+
+	// Large and small photos so workers finish out of order, with files the filter skips in between
+	directory := t.TempDir()
+	photos := make(map[string]string)
+	for i := 0; i < 60; i++ {
+		contents := "\xFF\xD8\xFF" + fmt.Sprint(i)
+		if i%7 == 0 {
+			contents += string(make([]byte, 1<<20))
+		}
+		photos[fmt.Sprintf("%02d/photo.jpg", i)] = contents
+		if i%5 == 0 {
+			photos[fmt.Sprintf("%02d/notes.txt", i)] = "not a photo"
+		}
+	}
+	writePhotos(t, directory, photos)
+
+	served := servePhotos(New(directory, 8, WithFullHashing(), WithFilter(DefaultFilter())))
+	if len(served) != 60 {
+		t.Fatalf("served %d photos; want 60", len(served))
+	}
+	for i, photoMetadata := range served {
+		if want := filepath.Join(directory, fmt.Sprintf("%02d/photo.jpg", i)); photoMetadata.Path != want {
+			t.Fatalf("served[%d] = %s; want %s", i, photoMetadata.Path, want)
+		}
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+
+	directory := t.TempDir()
+	photos := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		photos[fmt.Sprintf("%03d.jpg", i)] = fmt.Sprint("photo ", i)
+	}
+	writePhotos(t, directory, photos)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deduplicator := New(directory, 2, WithFullHashing())
+	photoChannel := make(chan DedupeFileMetadata)
+	var photoWaitGroup sync.WaitGroup
+	deduplicator.Serve(ctx, photoChannel, &photoWaitGroup)
+
+	// Stop after the first photo, the channel must still be closed
+	served := 0
+	for range photoChannel {
+		if served == 0 {
+			cancel()
+		}
+		served++
+	}
+	photoWaitGroup.Wait()
+
+	if served >= len(photos) {
+		t.Errorf("served %d photos after cancelling; want fewer than %d", served, len(photos))
+	}
+}
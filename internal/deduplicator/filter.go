@@ -0,0 +1,366 @@
+package deduplicator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reasons a file is kept out of the pipeline
+const (
+	SkipExcludedExtension = "excluded-extension"
+	SkipNotIncluded       = "not-included"
+	SkipExcludedPattern   = "excluded-pattern"
+	SkipNotMedia          = "not-media"
+	SkipUnreadable        = "unreadable"
+	SkipNotRegular        = "not-regular"
+)
+
+// Media types recognised by their magic bytes
+const (
+	MediaJPEG  = "jpeg"
+	MediaPNG   = "png"
+	MediaGIF   = "gif"
+	MediaTIFF  = "tiff"
+	MediaWebP  = "webp"
+	MediaHEIC  = "heic"
+	MediaAVIF  = "avif"
+	MediaBMP   = "bmp"
+	MediaRAW   = "raw"
+	MediaVideo = "video"
+)
+
+// Bytes read from the start of a file to sniff its type
+const sniffLength = 32
+
+// RAW formats built on TIFF, only told apart from a TIFF by their extension
+var tiffRawExtensions = map[string]bool{
+	".arw": true, ".dng": true, ".erf": true, ".kdc": true, ".mef": true, ".mos": true,
+	".nef": true, ".nrw": true, ".pef": true, ".sr2": true, ".srw": true, ".3fr": true,
+}
+
+// Decides which files enter the pipeline.
+// Extensions are compared case insensitively and may be given with or without the dot.
+// Patterns are globs (see filepath.Match) matched against both the file name and the
+// path relative to the directory being deduplicated.
+type Filter struct {
+	// Only files with one of these extensions are included, any extension when empty
+	IncludeExtensions []string
+	// Files with one of these extensions are skipped
+	ExcludeExtensions []string
+	// Only files matching one of these patterns are included, any file when empty
+	IncludePatterns []string
+	// Files matching one of these patterns are skipped
+	ExcludePatterns []string
+	// Only include files whose contents look like an image, RAW photo or video
+	SniffContent bool
+}
+
+// Filter skipping everything but media files along with common sidecar and system files
+func DefaultFilter() *Filter {
+	return &Filter{
+		ExcludeExtensions: []string{".xmp", ".aae", ".thm", ".db", ".ini"},
+		ExcludePatterns:   []string{".DS_Store", "._*", "Thumbs.db", "desktop.ini", ".picasa.ini", "*/@eaDir/*"},
+		SniffContent:      true,
+	}
+}
+
+// Only deduplicate files passing the filter, see DefaultFilter.
+// Skipped files are never served, SkippedFiles summarises them.
+func WithFilter(filter *Filter) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.filter = filter
+	}
+}
+
+// Thread safe count of skipped files by reason
+type skipCounter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newSkipCounter() *skipCounter {
+	return &skipCounter{counts: make(map[string]int)}
+}
+
+func (counter *skipCounter) add(path, reason string) {
+	log.Debug("Skipping ", path, " (", reason, ")")
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.counts[reason]++
+}
+
+// Copy of the counts
+func (counter *skipCounter) snapshot() map[string]int {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counts := make(map[string]int, len(counter.counts))
+	for reason, count := range counter.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// Filter files on their name alone, returns the reason when the file is skipped
+// or an empty string when it should be included
+func (filter *Filter) checkName(root, path string) string {
+	extension := strings.ToLower(filepath.Ext(path))
+
+	if len(filter.IncludeExtensions) > 0 && !containsExtension(filter.IncludeExtensions, extension) {
+		return SkipNotIncluded
+	}
+
+	if containsExtension(filter.ExcludeExtensions, extension) {
+		return SkipExcludedExtension
+	}
+
+	relativePath, err := filepath.Rel(root, path)
+	if err != nil {
+		relativePath = path
+	}
+
+	if len(filter.IncludePatterns) > 0 && !matchesPattern(filter.IncludePatterns, relativePath) {
+		return SkipNotIncluded
+	}
+
+	if matchesPattern(filter.ExcludePatterns, relativePath) {
+		return SkipExcludedPattern
+	}
+
+	return ""
+}
+
+// Filter a file on its contents, returns the reason when the file is skipped
+// or an empty string when it should be included
+func (filter *Filter) checkContent(path string) string {
+	if !filter.SniffContent {
+		return ""
+	}
+
+	mediaType, err := DetectMediaType(path)
+	if err != nil {
+		return SkipUnreadable
+	}
+	if mediaType == "" {
+		return SkipNotMedia
+	}
+	return ""
+}
+
+// Check if a file passes the filter, returns the reason when it is skipped
+// or an empty string when it should be included
+func (filter *Filter) Check(root, path string) string {
+	if reason := filter.checkName(root, path); reason != "" {
+		return reason
+	}
+	return filter.checkContent(path)
+}
+
+func containsExtension(extensions []string, extension string) bool {
+	for _, candidate := range extensions {
+		candidate = strings.ToLower(candidate)
+		if !strings.HasPrefix(candidate, ".") {
+			candidate = "." + candidate
+		}
+		if candidate == extension {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(patterns []string, relativePath string) bool {
+	slashPath := filepath.ToSlash(relativePath)
+	name := filepath.Base(relativePath)
+
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, slashPath); matched {
+			return true
+		}
+		// Let "*/dir/*" style patterns match at any depth
+		if strings.HasPrefix(pattern, "*/") && strings.HasSuffix(pattern, "/*") {
+			if strings.Contains("/"+slashPath, "/"+strings.Trim(pattern, "*/")+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sniff the type of a media file from its first bytes.
+// Returns an empty string for files which are not images, RAW photos or videos.
+func DetectMediaType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLength)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return sniffMediaType(file, header[:count], strings.ToLower(filepath.Ext(path))), nil
+}
+
+// Identify a media type from the start of a file. The extension is only used to
+// tell TIFF based RAW formats apart from plain TIFFs. Formats without a signature
+// (old QuickTime, MPEG transport streams) are confirmed by reading further into file.
+func sniffMediaType(file io.ReaderAt, header []byte, extension string) string {
+	switch {
+	case bytes.HasPrefix(header, jpegSignature):
+		return MediaJPEG
+	case bytes.HasPrefix(header, pngSignature):
+		return MediaPNG
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return MediaGIF
+	case isBMP(header):
+		return MediaBMP
+
+	// Canon CR2 is a TIFF with "CR" right after the header
+	case bytes.HasPrefix(header, []byte("II*\x00")) && len(header) >= 10 && string(header[8:10]) == "CR":
+		return MediaRAW
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		if tiffRawExtensions[extension] {
+			return MediaRAW
+		}
+		return MediaTIFF
+
+	// Olympus ORF, Panasonic RW2, Fujifilm RAF, Canon CRW, Sigma X3F
+	case bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIRS")),
+		bytes.HasPrefix(header, []byte("MMOR")), bytes.HasPrefix(header, []byte("IIU\x00")),
+		bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")), bytes.HasPrefix(header, []byte("FOVb")):
+		return MediaRAW
+	case len(header) >= 14 && bytes.HasPrefix(header, []byte("II\x1a\x00\x00\x00")) && string(header[6:14]) == "HEAPCCDR":
+		return MediaRAW
+
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "WEBP":
+		return MediaWebP
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "AVI ":
+		return MediaVideo
+
+	// ISO base media files (HEIC, AVIF, CR3, MP4, MOV, 3GP)
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		return sniffBrand(string(header[8:12]))
+	case isQuickTime(file, header):
+		return MediaVideo
+	case isTransportStream(file, header):
+		return MediaVideo
+
+	// Matroska/WebM, MPEG program stream, ASF/WMV
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}),
+		bytes.HasPrefix(header, []byte{0x00, 0x00, 0x01, 0xBA}),
+		bytes.HasPrefix(header, []byte{0x30, 0x26, 0xB2, 0x75}):
+		return MediaVideo
+	}
+
+	return ""
+}
+
+// "BM" starts plenty of text files, a bitmap also has a known DIB header size right after
+// its 14 byte file header (BITMAPCOREHEADER, BITMAPINFOHEADER and its V2 to V5 successors)
+func isBMP(header []byte) bool {
+	if len(header) < 18 || !bytes.HasPrefix(header, []byte("BM")) {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(header[14:18]) {
+	case 12, 40, 52, 56, 108, 124:
+		return true
+	}
+	return false
+}
+
+// Top level atoms an old QuickTime file starts with
+var quickTimeAtoms = map[string]bool{
+	"moov": true, "mdat": true, "wide": true, "free": true, "skip": true, "pnot": true, "uuid": true,
+}
+
+// Old QuickTime files start straight with an atom. Any file with "free" or "mdat" at offset 4
+// would pass on the type alone, so the atom also needs a plausible size and has to be followed
+// by another top level atom.
+func isQuickTime(file io.ReaderAt, header []byte) bool {
+	if len(header) < 8 || !quickTimeAtoms[string(header[4:8])] {
+		return false
+	}
+
+	size := uint64(binary.BigEndian.Uint32(header[:4]))
+	// A size of 1 is followed by the real 64 bit size
+	if size == 1 {
+		if len(header) < 16 {
+			return false
+		}
+		size = binary.BigEndian.Uint64(header[8:16])
+		if size < 16 {
+			return false
+		}
+	}
+	// 0 runs to the end of the file, which leaves no second atom
+	if size < 8 || size > math.MaxInt64 {
+		return false
+	}
+
+	var next [8]byte
+	if _, err := file.ReadAt(next[:], int64(size)); err != nil {
+		return false
+	}
+	nextSize := binary.BigEndian.Uint32(next[:4])
+	if nextSize != 0 && nextSize != 1 && nextSize < 8 {
+		return false
+	}
+	return quickTimeAtoms[string(next[4:8])]
+}
+
+// Packet sizes of MPEG transport streams: 188 bytes, or 192 with the timecode
+// BDAV/AVCHD (.mts, .m2ts) puts in front of every packet
+var transportStreamPackets = []struct {
+	size, offset int64
+}{{188, 0}, {192, 4}}
+
+// Number of packets that have to start with a sync byte
+const transportStreamSyncs = 3
+
+// MPEG transport streams have no signature, only a 0x47 sync byte at the start of every packet
+func isTransportStream(file io.ReaderAt, header []byte) bool {
+	for _, packet := range transportStreamPackets {
+		if int64(len(header)) <= packet.offset || header[packet.offset] != 0x47 {
+			continue
+		}
+
+		synced := true
+		for i := int64(1); i < transportStreamSyncs && synced; i++ {
+			var sync [1]byte
+			_, err := file.ReadAt(sync[:], packet.offset+i*packet.size)
+			synced = err == nil && sync[0] == 0x47
+		}
+		if synced {
+			return true
+		}
+	}
+	return false
+}
+
+// Media type of an ISO base media file from its major brand
+func sniffBrand(brand string) string {
+	switch brand {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+		return MediaHEIC
+	case "avif", "avis":
+		return MediaAVIF
+	case "crx ":
+		return MediaRAW
+	}
+	// mp41, mp42, isom, qt, M4V, 3gp and friends
+	return MediaVideo
+}
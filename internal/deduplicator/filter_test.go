@@ -0,0 +1,126 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSniffMediaType(t *testing.T) {
+	transportPacket := "\x47" + strings.Repeat("\x00", 187)
+
+	tests := []struct {
+		header    string
+		extension string
+		want      string
+	}{
+		{"\xFF\xD8\xFF\xE1....Exif", ".jpg", MediaJPEG},
+		{"\x89PNG\r\n\x1A\n....IHDR", ".png", MediaPNG},
+		{"GIF89a......", ".gif", MediaGIF},
+		{"BM\x36\x00\x0C\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00", ".bmp", MediaBMP},
+		{"BM\x1A\x00\x00\x00\x00\x00\x00\x00\x1A\x00\x00\x00\x0C\x00\x00\x00", ".bmp", MediaBMP},
+		{"BMW owners club meeting notes", ".txt", ""},
+		{"II*\x00\x10\x00\x00\x00CR\x02\x00", ".cr2", MediaRAW},
+		{"II*\x00\x08\x00\x00\x00\x00\x00", ".nef", MediaRAW},
+		{"MM\x00*\x00\x00\x00\x08\x00\x00", ".dng", MediaRAW},
+		{"II*\x00\x08\x00\x00\x00\x00\x00", ".tif", MediaTIFF},
+		{"IIRO\x08\x00\x00\x00", ".orf", MediaRAW},
+		{"FUJIFILMCCD-RAW 0201", ".raf", MediaRAW},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", ".webp", MediaWebP},
+		{"RIFF\x00\x00\x00\x00AVI LIST", ".avi", MediaVideo},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", ".heic", MediaHEIC},
+		{"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00", ".heif", MediaHEIC},
+		{"\x00\x00\x00\x18ftypcrx \x00\x00\x00\x00", ".cr3", MediaRAW},
+		{"\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00", ".mov", MediaVideo},
+		{"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", ".mp4", MediaVideo},
+		{"\x00\x00\x00\x08wide\x00\x00\x00\x10mdat\x00\x00\x00\x00\x00\x00\x00\x00", ".mov", MediaVideo},
+		{"\x00\x00\x00\x10moov\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00mdat", ".mov", MediaVideo},
+		{"\x00\x00\x00\x01mdat\x00\x00\x00\x00\x00\x00\x00\x18\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x08free", ".mov", MediaVideo},
+		{"\x00\x00\x00\x08wide\x00\x00\x00\x00", ".mov", ""},
+		{"\x00\x00\x00\x08wide and then some text", ".mov", ""},
+		{"\x00\x00\x00\x00mdat\x00\x00\x00\x08free", ".mov", ""},
+		{"My freedom", ".txt", ""},
+		{strings.Repeat(transportPacket, 3), ".mts", MediaVideo},
+		{strings.Repeat("\x00\x01\x02\x03"+transportPacket, 3), ".m2ts", MediaVideo},
+		{strings.Repeat(transportPacket, 2), ".mts", ""},
+		{"Groceries " + strings.Repeat("and more ", 50), ".txt", ""},
+		{"\x1A\x45\xDF\xA3\x01\x00\x00\x00", ".mkv", MediaVideo},
+		{"\x00\x00\x00\x01Bud1\x00\x00", "", ""},
+		{"SQLite format 3\x00", ".db", ""},
+		{"<x:xmpmeta xmlns:x=", ".xmp", ""},
+		{"", ".jpg", ""},
+	}
+
+	for _, test := range tests {
+		header := []byte(test.header)
+		if len(header) > sniffLength {
+			header = header[:sniffLength]
+		}
+		if mediaType := sniffMediaType(strings.NewReader(test.header), header, test.extension); mediaType != test.want {
+			t.Errorf("sniffMediaType(%q, %s) = %q; want %q", test.header, test.extension, mediaType, test.want)
+		}
+	}
+}
+
+func TestFilterCheckName(t *testing.T) {
+
+	filter := &Filter{
+		IncludeExtensions: []string{"jpg", ".PNG"},
+		ExcludePatterns:   []string{"._*", "*/@eaDir/*", "drafts/*"},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"photo.jpg", ""},
+		{"nested/photo.JPG", ""},
+		{"photo.png", ""},
+		{"photo.xmp", SkipNotIncluded},
+		{"._photo.jpg", SkipExcludedPattern},
+		{"nested/@eaDir/photo.jpg", SkipExcludedPattern},
+		{"drafts/photo.jpg", SkipExcludedPattern},
+		{"nested/drafts/photo.jpg", ""},
+	}
+
+	root := filepath.FromSlash("/photos")
+	for _, test := range tests {
+		path := filepath.Join(root, filepath.FromSlash(test.path))
+		if reason := filter.checkName(root, path); reason != test.want {
+			t.Errorf("checkName(%s) = %q; want %q", test.path, reason, test.want)
+		}
+	}
+}
+
+func TestServeWithFilter(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"photo.jpg":      "\xFF\xD8\xFF\xE0 a photo",
+		"copy.jpg":       "\xFF\xD8\xFF\xE0 a photo",
+		"photo.xmp":      "<x:xmpmeta>",
+		".DS_Store":      "\x00\x00\x00\x01Bud1",
+		"notes.txt":      "hello",
+		"renamed.jpg":    "not really a photo",
+		"nested/vid.mov": "\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00",
+	})
+
+	deduplicator := New(directory, 2, WithFilter(DefaultFilter()))
+	served := servePhotos(deduplicator)
+
+	if len(served) != 3 {
+		t.Errorf("len(served) = %d; want 3", len(served))
+	}
+
+	skipped := deduplicator.SkippedFiles()
+	want := map[string]int{
+		SkipExcludedExtension: 1,
+		SkipExcludedPattern:   1,
+		SkipNotMedia:          2,
+	}
+	for reason, count := range want {
+		if skipped[reason] != count {
+			t.Errorf("skipped[%s] = %d; want %d", reason, skipped[reason], count)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package deduplicator
+
+import (
+	"sort"
+	"sync"
+)
+
+// Every copy of the same contents found by a scan
+type DuplicateGroup struct {
+	// Same as DedupeFileMetadata.GroupID of every member
+	ID int
+	// Digest shared by every member
+	Hash string
+	// Size of each member in bytes
+	Size int64
+	// Every member including the keeper, sorted
+	Paths []string
+	// Copy that is kept, every other member is a duplicate of it
+	Keeper string
+}
+
+// Assigns group ids to served files and collects the duplicate groups
+type groupIndex struct {
+	lock   sync.Mutex
+	nextID int
+	// Groups by keeper path
+	groups map[string]*DuplicateGroup
+	// Paths already in a group
+	members map[string]bool
+}
+
+func newGroupIndex() *groupIndex {
+	return &groupIndex{groups: make(map[string]*DuplicateGroup), members: make(map[string]bool)}
+}
+
+// Add a served file to the group of its keeper, setting its GroupID.
+// A duplicate may be served before its keeper, or its keeper not at all when an incremental
+// scan left it alone, so a duplicate starting a group adds its keeper too.
+func (index *groupIndex) add(photoMetadata *DedupeFileMetadata) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	keeper := photoMetadata.Path
+	if photoMetadata.DuplicatePath != "" {
+		keeper = photoMetadata.DuplicatePath
+	}
+
+	group, ok := index.groups[keeper]
+	if !ok {
+		index.nextID++
+		group = &DuplicateGroup{ID: index.nextID, Keeper: keeper}
+		index.groups[keeper] = group
+	}
+
+	for _, path := range []string{keeper, photoMetadata.Path} {
+		if !index.members[path] {
+			index.members[path] = true
+			group.Paths = append(group.Paths, path)
+		}
+	}
+	if photoMetadata.Hash != "" {
+		group.Hash = photoMetadata.Hash
+	}
+	if photoMetadata.Size != 0 {
+		group.Size = photoMetadata.Size
+	}
+
+	photoMetadata.GroupID = group.ID
+}
+
+// Keeper of every grouped path, by path
+func (index *groupIndex) keepers() map[string]string {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	keepers := make(map[string]string, len(index.members))
+	for keeper, group := range index.groups {
+		for _, path := range group.Paths {
+			keepers[path] = keeper
+		}
+	}
+	return keepers
+}
+
+// Groups with more than one member, sorted by keeper
+func (index *groupIndex) duplicates() []DuplicateGroup {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	groups := []DuplicateGroup{}
+	for _, group := range index.groups {
+		if len(group.Paths) < 2 {
+			continue
+		}
+		duplicate := *group
+		duplicate.Paths = append([]string(nil), group.Paths...)
+		sort.Strings(duplicate.Paths)
+		groups = append(groups, duplicate)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Keeper < groups[j].Keeper
+	})
+	return groups
+}
+
+// Every duplicate group found by the last scan, sorted by keeper.
+// Complete once all photos have been served.
+func (deduplicator *PhotoDeduplicator) Groups() []DuplicateGroup {
+	return deduplicator.groups.duplicates()
+}
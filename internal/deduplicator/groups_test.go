@@ -0,0 +1,70 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a/beach.jpg": "beach",
+		"b/beach.jpg": "beach",
+		"c/beach.jpg": "beach",
+		"a/dune.jpg":  "dune!",
+		"b/dune.jpg":  "dune!",
+		"a/lake.jpg":  "lake",
+	})
+
+	deduplicator := New(directory, 4, WithKeeperPolicy(KeepShortestPath))
+	served := servePhotos(deduplicator)
+
+	groups := deduplicator.Groups()
+	if len(groups) != 2 {
+		t.Fatalf("len(groups) = %d; want 2", len(groups))
+	}
+
+	beach := groups[0]
+	if beach.Keeper != filepath.Join(directory, "a/beach.jpg") {
+		t.Errorf("beach.Keeper = %s; want a/beach.jpg", beach.Keeper)
+	}
+	if len(beach.Paths) != 3 || beach.Paths[2] != filepath.Join(directory, "c/beach.jpg") {
+		t.Errorf("beach.Paths = %v; want a, b and c/beach.jpg", beach.Paths)
+	}
+	if beach.Size != 5 || beach.Hash == "" {
+		t.Errorf("beach.Size, beach.Hash = %d, %s; want 5 and a hash", beach.Size, beach.Hash)
+	}
+
+	// Served files carry the id of their group, unique files get their own
+	ids := make(map[string]int)
+	for _, photoMetadata := range served {
+		relativePath, _ := filepath.Rel(directory, photoMetadata.Path)
+		ids[relativePath] = photoMetadata.GroupID
+	}
+
+	if ids["a/beach.jpg"] != beach.ID || ids["c/beach.jpg"] != beach.ID {
+		t.Errorf("beach GroupIDs = %d, %d; want %d", ids["a/beach.jpg"], ids["c/beach.jpg"], beach.ID)
+	}
+	if ids["a/lake.jpg"] == 0 || ids["a/lake.jpg"] == beach.ID || ids["a/lake.jpg"] == groups[1].ID {
+		t.Errorf("lake GroupID = %d; want an id of its own", ids["a/lake.jpg"])
+	}
+}
+
+func TestGroupsStreaming(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a.jpg": "same",
+		"b.jpg": "same",
+		"c.jpg": "same",
+	})
+
+	deduplicator := New(directory, 4)
+	servePhotos(deduplicator)
+
+	groups := deduplicator.Groups()
+	if len(groups) != 1 || len(groups[0].Paths) != 3 {
+		t.Fatalf("Groups() = %v; want one group of 3", groups)
+	}
+}
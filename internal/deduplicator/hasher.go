@@ -0,0 +1,89 @@
+package deduplicator
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash algorithm used to fingerprint photos
+type Hasher interface {
+	// Name of the algorithm, recorded with every digest it produces
+	Name() string
+	// Create a new hash.Hash for a single file
+	New() hash.Hash
+}
+
+// Hasher backed by a hash.Hash constructor
+type namedHasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+func (hasher namedHasher) Name() string {
+	return hasher.name
+}
+
+func (hasher namedHasher) New() hash.Hash {
+	return hasher.newHash()
+}
+
+var (
+	// SHA-256, the default
+	SHA256 Hasher = namedHasher{"sha256", sha256.New}
+	// SHA-1, faster than SHA-256 and still fine for finding duplicates
+	SHA1 Hasher = namedHasher{"sha1", sha1.New}
+	// 256 bit BLAKE2b, cryptographic and faster than SHA-256 on 64 bit machines
+	BLAKE2b Hasher = namedHasher{"blake2b", newBlake2b}
+	// 64 bit xxHash, fast but not cryptographic. Pair it with verification when purging.
+	XXHash Hasher = namedHasher{"xxhash", func() hash.Hash { return xxhash.New() }}
+)
+
+// Every built in hasher
+var hashers = []Hasher{SHA256, SHA1, BLAKE2b, XXHash}
+
+// Look up a built in hasher by name
+func HasherByName(name string) (Hasher, error) {
+	for _, hasher := range hashers {
+		if hasher.Name() == strings.ToLower(name) {
+			return hasher, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %s", name)
+}
+
+// Names of every built in hasher
+func HasherNames() []string {
+	var names []string
+	for _, hasher := range hashers {
+		names = append(names, hasher.Name())
+	}
+	return names
+}
+
+// Format a hash sum as a digest string, prefixed by the algorithm that produced it
+// so digests of different algorithms never compare equal
+func formatDigest(hasher Hasher, sum []byte) string {
+	return hasher.Name() + ":" + base64.URLEncoding.EncodeToString(sum)
+}
+
+// Algorithm that produced a digest
+func DigestAlgorithm(digest string) string {
+	algorithm := strings.SplitN(digest, ":", 2)
+	if len(algorithm) != 2 {
+		return ""
+	}
+	return algorithm[0]
+}
+
+func newBlake2b() hash.Hash {
+	// Only fails when given a key that is too long
+	h, _ := blake2b.New256(nil)
+	return h
+}
@@ -0,0 +1,69 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHasherByName(t *testing.T) {
+
+	for _, name := range HasherNames() {
+		hasher, err := HasherByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasher.Name() != name {
+			t.Errorf("HasherByName(%s).Name() = %s; want %s", name, hasher.Name(), name)
+		}
+	}
+
+	if _, err := HasherByName("md5"); err == nil {
+		t.Errorf("HasherByName(md5) error = nil; want error")
+	}
+}
+
+func TestHashPhotoAlgorithms(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{"photo.jpg": "photo"})
+	photoPath := filepath.Join(directory, "photo.jpg")
+
+	digests := make(map[string]string)
+	for _, hasher := range hashers {
+		digest, err := hashPhoto(hasher, photoPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if DigestAlgorithm(digest) != hasher.Name() {
+			t.Errorf("DigestAlgorithm(%s) = %s; want %s", digest, DigestAlgorithm(digest), hasher.Name())
+		}
+
+		if other, ok := digests[digest]; ok {
+			t.Errorf("%s and %s produced the same digest %s", hasher.Name(), other, digest)
+		}
+		digests[digest] = hasher.Name()
+	}
+}
+
+func TestServeWithHasher(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a.jpg":      "same photo",
+		"a-copy.jpg": "same photo",
+	})
+
+	for _, photoMetadata := range servePhotos(New(directory, 2, WithHasher(XXHash))) {
+		if photoMetadata.Status != StatusDuplicate {
+			continue
+		}
+
+		if DigestAlgorithm(photoMetadata.Hash) != XXHash.Name() {
+			t.Errorf("DigestAlgorithm(%s) = %s; want %s", photoMetadata.Hash, DigestAlgorithm(photoMetadata.Hash), XXHash.Name())
+		}
+		return
+	}
+
+	t.Errorf("no duplicate served")
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package deduplicator
+
+import "os"
+
+// No inodes here, files are only identified by path, size and modification time
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package deduplicator
+
+import (
+	"os"
+	"syscall"
+)
+
+// Device and inode of a file. A file replaced by another one under the same name (saved to a
+// temporary file and renamed) gets a new inode, a file truncated and rewritten in place keeps
+// its inode and only shows up in its size and modification time.
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -0,0 +1,136 @@
+package deduplicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Version of the index file format
+const IndexVersion = 1
+
+// One original of an index file
+type IndexEntry struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Originals by full hash, written by SaveIndex
+type indexFile struct {
+	Version   int          `json:"version"`
+	Created   time.Time    `json:"created"`
+	Algorithm string       `json:"algorithm"`
+	Content   bool         `json:"content"`
+	Entries   []IndexEntry `json:"entries"`
+}
+
+// Write every original with a known full hash to a file, so another run or another machine can
+// dedupe new files against it with LoadIndex. Only files that had to be hashed are known, use
+// WithFullHashing for a complete index.
+// The photo map holds the first copy found, the index holds the copy picked as keeper instead
+// since the others may be purged.
+func (deduplicator *PhotoDeduplicator) SaveIndex(path string) error {
+	index := indexFile{
+		Version:   IndexVersion,
+		Created:   time.Now(),
+		Algorithm: deduplicator.hasher.Name(),
+		Content:   deduplicator.contentHashing,
+		Entries:   make([]IndexEntry, 0, len(deduplicator.photoMap)),
+	}
+
+	keepers := deduplicator.groups.keepers()
+	for hash, entry := range deduplicator.photoMap {
+		path := entry.path
+		if keeper, ok := keepers[path]; ok {
+			path = keeper
+		}
+		index.Entries = append(index.Entries, IndexEntry{Hash: hash, Path: absolutePath(path), Size: entry.size})
+	}
+	sort.Slice(index.Entries, func(i, j int) bool {
+		return index.Entries[i].Path < index.Entries[j].Path
+	})
+
+	encoded, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Read an index written by SaveIndex. Files served afterwards with the same contents as one of its
+// originals are duplicates of it, even when the original does not exist on this machine.
+// The index must use the same hash algorithm and content mode as the deduplicator.
+// Returns the number of originals loaded.
+func (deduplicator *PhotoDeduplicator) LoadIndex(path string) (int, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	index := indexFile{}
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return 0, fmt.Errorf("unable to parse index %s: %w", path, err)
+	}
+	if index.Version != IndexVersion {
+		return 0, fmt.Errorf("unsupported index version %d in %s", index.Version, path)
+	}
+	if index.Algorithm != deduplicator.hasher.Name() {
+		return 0, fmt.Errorf("index %s was built with %s, not %s", path, index.Algorithm, deduplicator.hasher.Name())
+	}
+	if index.Content != deduplicator.contentHashing {
+		return 0, fmt.Errorf("index %s was built with content hashing set to %t", path, index.Content)
+	}
+
+	loaded := 0
+	for _, entry := range index.Entries {
+		if DigestAlgorithm(entry.Hash) != index.Algorithm {
+			return loaded, fmt.Errorf("index %s has a %s digest for %s", path, DigestAlgorithm(entry.Hash), entry.Path)
+		}
+		// Originals found on this machine win
+		if _, ok := deduplicator.photoMap[entry.Hash]; ok {
+			continue
+		}
+		deduplicator.photoMap[entry.Hash] = indexEntry{path: entry.Path, size: entry.Size, external: true}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// Seed every original loaded from an index into a new candidate index
+func (deduplicator *PhotoDeduplicator) seedExternal(index *candidateIndex) {
+	for hash, entry := range deduplicator.photoMap {
+		if entry.external {
+			index.seed(&candidate{path: entry.path, size: entry.size, fullHash: hash, external: true})
+		}
+	}
+}
+
+func absolutePath(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return absolute
+}
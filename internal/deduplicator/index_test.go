@@ -0,0 +1,148 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoadIndex(t *testing.T) {
+
+	directory := t.TempDir()
+	library := filepath.Join(directory, "library")
+	imports := filepath.Join(directory, "imports")
+	indexPath := filepath.Join(directory, "library.index")
+	writePhotos(t, library, map[string]string{"a.jpg": "photo a", "b.jpg": "photo bb"})
+	writePhotos(t, imports, map[string]string{"copy.jpg": "photo a", "new.jpg": "photo c"})
+
+	builder := New(library, 2, WithFullHashing())
+	servePhotos(builder)
+	if err := builder.SaveIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rescanning the library against its own index finds nothing
+	rescan := New(library, 2)
+	if _, err := rescan.LoadIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, photoMetadata := range servePhotos(rescan) {
+		if photoMetadata.DuplicatePath != "" {
+			t.Errorf("%s DuplicatePath = %s; want empty", photoMetadata.Path, photoMetadata.DuplicatePath)
+		}
+	}
+
+	// The library is not needed to dedupe against it
+	if err := os.RemoveAll(library); err != nil {
+		t.Fatal(err)
+	}
+
+	importer := New(imports, 2)
+	loaded, err := importer.LoadIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 {
+		t.Errorf("LoadIndex() = %d; want 2", loaded)
+	}
+
+	want := map[string]string{
+		"copy.jpg": filepath.Join(library, "a.jpg"),
+		"new.jpg":  "",
+	}
+	for _, photoMetadata := range servePhotos(importer) {
+		name := filepath.Base(photoMetadata.Path)
+		if photoMetadata.DuplicatePath != want[name] {
+			t.Errorf("%s DuplicatePath = %s; want %s", name, photoMetadata.DuplicatePath, want[name])
+		}
+	}
+}
+
+func TestLoadIndexOtherAlgorithm(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{"a.jpg": "photo a"})
+	indexPath := filepath.Join(t.TempDir(), "library.index")
+
+	builder := New(directory, 1, WithFullHashing())
+	servePhotos(builder)
+	if err := builder.SaveIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(directory, 1, WithHasher(SHA1)).LoadIndex(indexPath); err == nil {
+		t.Errorf("LoadIndex() with another algorithm error = nil; want an error")
+	}
+	if _, err := New(directory, 1, WithContentHashing()).LoadIndex(indexPath); err == nil {
+		t.Errorf("LoadIndex() with content hashing error = nil; want an error")
+	}
+}
+
+func TestSaveIndexKeeper(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{"a/looooooooooooong.jpg": "photo a", "b.jpg": "photo a"})
+	indexPath := filepath.Join(t.TempDir(), "library.index")
+
+	// Both copies are hashed by several workers, whichever comes first is in the photo map
+	for i := 0; i < 10; i++ {
+		builder := New(directory, 2, WithFullHashing(), WithKeeperPolicy(KeepShortestPath))
+		servePhotos(builder)
+		if err := builder.SaveIndex(indexPath); err != nil {
+			t.Fatal(err)
+		}
+
+		importer := New(t.TempDir(), 1)
+		if _, err := importer.LoadIndex(indexPath); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range importer.photoMap {
+			if want := filepath.Join(directory, "b.jpg"); entry.path != want {
+				t.Fatalf("index path = %s; want the keeper %s", entry.path, want)
+			}
+		}
+	}
+}
+
+func TestLoadIndexVerifyMissing(t *testing.T) {
+
+	directory := t.TempDir()
+	library := filepath.Join(directory, "library")
+	imports := filepath.Join(directory, "imports")
+	indexPath := filepath.Join(directory, "library.index")
+	writePhotos(t, library, map[string]string{"a.jpg": "photo a"})
+	writePhotos(t, imports, map[string]string{"copy.jpg": "photo a", "other/copy.jpg": "photo a"})
+
+	for _, content := range []bool{false, true} {
+		var options []Option
+		if content {
+			options = append(options, WithContentHashing())
+		}
+		builder := New(library, 1, append(options, WithFullHashing())...)
+		servePhotos(builder)
+		if err := builder.SaveIndex(indexPath); err != nil {
+			t.Fatal(err)
+		}
+
+		// As if the index came from another machine
+		hidden := library + ".elsewhere"
+		if err := os.Rename(library, hidden); err != nil {
+			t.Fatal(err)
+		}
+
+		importer := New(imports, 1, options...)
+		importer.SetVerify(true)
+		if _, err := importer.LoadIndex(indexPath); err != nil {
+			t.Fatal(err)
+		}
+		for _, photoMetadata := range servePhotos(importer) {
+			if photoMetadata.DuplicatePath == filepath.Join(library, "a.jpg") {
+				t.Errorf("content %t: %s DuplicatePath = %s; want an unverified original to be ignored", content, photoMetadata.Path, photoMetadata.DuplicatePath)
+			}
+		}
+
+		if err := os.Rename(hidden, library); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package deduplicator
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Decides which copy of a duplicate group is kept
+type KeeperPolicy struct {
+	name string
+	// Negative when first should be kept over second, positive for the opposite, 0 when undecided
+	compare func(first, second *DedupeFileMetadata) int
+	// Compares EXIF metadata, see WithExif
+	needsExif bool
+}
+
+// Name of the policy, as accepted by KeeperPolicyByName
+func (policy KeeperPolicy) Name() string {
+	return policy.name
+}
+
+var (
+	// Keep the file with the oldest modification time
+	KeepOldest = KeeperPolicy{name: "oldest", compare: func(first, second *DedupeFileMetadata) int {
+		switch {
+		case first.ModTime.Before(second.ModTime):
+			return -1
+		case second.ModTime.Before(first.ModTime):
+			return 1
+		}
+		return 0
+	}}
+	// Keep the file with the shortest path
+	KeepShortestPath = KeeperPolicy{name: "shortest-path", compare: func(first, second *DedupeFileMetadata) int {
+		return len(first.Path) - len(second.Path)
+	}}
+	// Keep the photo with the most pixels according to its metadata
+	KeepHighestResolution = KeeperPolicy{name: "resolution", needsExif: true, compare: func(first, second *DedupeFileMetadata) int {
+		return compareInts(pixels(second), pixels(first))
+	}}
+	// Keep the photo with the most metadata fields filled in
+	KeepRichestMetadata = KeeperPolicy{name: "metadata", needsExif: true, compare: func(first, second *DedupeFileMetadata) int {
+		return compareInts(metadataFields(second), metadataFields(first))
+	}}
+	// Keep the largest file
+	KeepLargest = KeeperPolicy{name: "largest", compare: func(first, second *DedupeFileMetadata) int {
+		switch {
+		case first.Size > second.Size:
+			return -1
+		case first.Size < second.Size:
+			return 1
+		}
+		return 0
+	}}
+)
+
+// Keep files inside of directory over files anywhere else
+func KeepInDirectory(directory string) KeeperPolicy {
+	absoluteDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		absoluteDirectory = directory
+	}
+
+	inDirectory := func(path string) int {
+		absolutePath, err := filepath.Abs(path)
+		if err != nil {
+			return 0
+		}
+		relativePath, err := filepath.Rel(absoluteDirectory, absolutePath)
+		if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			return 0
+		}
+		return 1
+	}
+
+	return KeeperPolicy{name: "prefix:" + directory, compare: func(first, second *DedupeFileMetadata) int {
+		return inDirectory(second.Path) - inDirectory(first.Path)
+	}}
+}
+
+// Look up a keeper policy by name (oldest, shortest-path, resolution, metadata, largest
+// or prefix:<directory>)
+func KeeperPolicyByName(name string) (KeeperPolicy, error) {
+	if strings.HasPrefix(name, "prefix:") {
+		directory := strings.TrimPrefix(name, "prefix:")
+		if directory == "" {
+			return KeeperPolicy{}, fmt.Errorf("keeper policy %s is missing a directory", name)
+		}
+		return KeepInDirectory(directory), nil
+	}
+
+	for _, policy := range []KeeperPolicy{KeepOldest, KeepShortestPath, KeepHighestResolution, KeepRichestMetadata, KeepLargest} {
+		if policy.name == strings.ToLower(name) {
+			return policy, nil
+		}
+	}
+	return KeeperPolicy{}, fmt.Errorf("unknown keeper policy %s", name)
+}
+
+// Choose which copy of every duplicate group is kept using policies, applied in order
+// until one prefers a copy. Remaining ties go to the lowest path so the result doesn't
+// depend on the order files were processed in.
+// Files are no longer served as they are processed but all at once when the scan is done.
+func WithKeeperPolicy(policies ...KeeperPolicy) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.keeperPolicies = policies
+		for _, policy := range policies {
+			if policy.needsExif {
+				deduplicator.readExif = true
+			}
+		}
+	}
+}
+
+// Pick the keeper of every duplicate group among served files.
+// The keeper takes over the status of the file first seen, every other copy becomes
+// its duplicate. Returned sorted by path.
+func selectKeepers(served []DedupeFileMetadata, policies []KeeperPolicy) []DedupeFileMetadata {
+	// Every duplicate points at the first copy seen
+	groups := make(map[string][]int)
+	for i, photoMetadata := range served {
+		if photoMetadata.DuplicatePath != "" {
+			groups[photoMetadata.DuplicatePath] = append(groups[photoMetadata.DuplicatePath], i)
+		}
+	}
+
+	positions := make(map[string]int, len(served))
+	for i, photoMetadata := range served {
+		positions[photoMetadata.Path] = i
+	}
+
+	// First copies replaced by another keeper
+	replaced := make(map[string]string)
+
+	for firstPath, duplicates := range groups {
+		first, ok := positions[firstPath]
+		if !ok {
+			continue
+		}
+		members := append([]int{first}, duplicates...)
+
+		sort.Slice(members, func(i, j int) bool {
+			return preferred(&served[members[i]], &served[members[j]], policies)
+		})
+		keeper := members[0]
+
+		// Only the copies that were hashed know the hash
+		hash := ""
+		for _, member := range members {
+			if served[member].Hash != "" {
+				hash = served[member].Hash
+			}
+		}
+
+		if keeper != first {
+			replaced[firstPath] = served[keeper].Path
+
+			// Whatever was found out about the contents carries over to the keeper
+			served[keeper].Status = served[first].Status
+			served[keeper].CollisionPath = served[first].CollisionPath
+			served[keeper].SimilarPath = served[first].SimilarPath
+			served[keeper].Similarity = served[first].Similarity
+			served[keeper].DuplicatePath = ""
+
+			served[first].Status = StatusDuplicate
+			served[first].CollisionPath = ""
+			served[first].SimilarPath = ""
+			served[first].Similarity = 1
+		}
+
+		for _, member := range members {
+			served[member].Hash = hash
+			if member != keeper {
+				served[member].DuplicatePath = served[keeper].Path
+			}
+		}
+	}
+
+	// Near duplicates may point at a copy that is no longer kept
+	for i := range served {
+		if keeper, ok := replaced[served[i].SimilarPath]; ok {
+			served[i].SimilarPath = keeper
+		}
+	}
+
+	sort.Slice(served, func(i, j int) bool {
+		return served[i].Path < served[j].Path
+	})
+	return served
+}
+
+// Check if first should be kept over second
+func preferred(first, second *DedupeFileMetadata, policies []KeeperPolicy) bool {
+	for _, policy := range policies {
+		if order := policy.compare(first, second); order != 0 {
+			return order < 0
+		}
+	}
+	return first.Path < second.Path
+}
+
+func compareInts(first, second int) int {
+	switch {
+	case first < second:
+		return -1
+	case first > second:
+		return 1
+	}
+	return 0
+}
+
+// Number of pixels of a photo, 0 when unknown
+func pixels(photoMetadata *DedupeFileMetadata) int {
+	if photoMetadata.Exif == nil {
+		return 0
+	}
+	return photoMetadata.Exif.Width * photoMetadata.Exif.Height
+}
+
+// Number of metadata fields a photo has filled in
+func metadataFields(photoMetadata *DedupeFileMetadata) int {
+	metadata := photoMetadata.Exif
+	if metadata == nil {
+		return 0
+	}
+
+	count := 0
+	for _, filled := range []bool{
+		!metadata.CaptureTime.IsZero(),
+		metadata.Make != "",
+		metadata.Model != "",
+		metadata.Width != 0 && metadata.Height != 0,
+		metadata.Orientation != 0,
+		metadata.GPS != nil,
+	} {
+		if filled {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,108 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelectKeepers(t *testing.T) {
+
+	now := time.Now()
+	served := []DedupeFileMetadata{
+		{Path: "photos/2021/beach.jpg", Status: StatusNearDuplicate, SimilarPath: "photos/dune.jpg", ModTime: now},
+		{Path: "backup/beach.jpg", Status: StatusDuplicate, DuplicatePath: "photos/2021/beach.jpg", Hash: "sha256:a", ModTime: now.Add(-time.Hour)},
+		{Path: "b/beach.jpg", Status: StatusDuplicate, DuplicatePath: "photos/2021/beach.jpg", Hash: "sha256:a", ModTime: now.Add(-time.Hour)},
+		{Path: "photos/sunset.jpg", Status: StatusNearDuplicate, SimilarPath: "photos/2021/beach.jpg", ModTime: now},
+	}
+
+	kept := selectKeepers(served, []KeeperPolicy{KeepOldest})
+
+	want := map[string]string{
+		"b/beach.jpg":           "",
+		"backup/beach.jpg":      "b/beach.jpg",
+		"photos/2021/beach.jpg": "b/beach.jpg",
+		"photos/sunset.jpg":     "",
+	}
+
+	for _, photoMetadata := range kept {
+		if photoMetadata.DuplicatePath != want[photoMetadata.Path] {
+			t.Errorf("%s: DuplicatePath = %s; want %s", photoMetadata.Path, photoMetadata.DuplicatePath, want[photoMetadata.Path])
+		}
+
+		switch photoMetadata.Path {
+		case "b/beach.jpg":
+			// Takes over the status of the copy first seen
+			if photoMetadata.Status != StatusNearDuplicate || photoMetadata.SimilarPath != "photos/dune.jpg" {
+				t.Errorf("b/beach.jpg: Status, SimilarPath = %s, %s; want near-duplicate, photos/dune.jpg", photoMetadata.Status, photoMetadata.SimilarPath)
+			}
+		case "photos/2021/beach.jpg":
+			if photoMetadata.Status != StatusDuplicate || photoMetadata.Hash != "sha256:a" {
+				t.Errorf("photos/2021/beach.jpg: Status, Hash = %s, %s; want duplicate, sha256:a", photoMetadata.Status, photoMetadata.Hash)
+			}
+		case "photos/sunset.jpg":
+			if photoMetadata.SimilarPath != "b/beach.jpg" {
+				t.Errorf("photos/sunset.jpg: SimilarPath = %s; want b/beach.jpg", photoMetadata.SimilarPath)
+			}
+		}
+	}
+
+	if kept[0].Path != "b/beach.jpg" {
+		t.Errorf("kept[0].Path = %s; want b/beach.jpg (sorted by path)", kept[0].Path)
+	}
+}
+
+func TestKeeperPolicyByName(t *testing.T) {
+
+	for _, name := range []string{"oldest", "shortest-path", "resolution", "metadata", "largest", "prefix:photos/"} {
+		policy, err := KeeperPolicyByName(name)
+		if err != nil {
+			t.Errorf("KeeperPolicyByName(%s) error = %v; want nil", name, err)
+			continue
+		}
+		if policy.Name() != name {
+			t.Errorf("KeeperPolicyByName(%s).Name() = %s", name, policy.Name())
+		}
+	}
+
+	for _, name := range []string{"newest", "prefix:"} {
+		if _, err := KeeperPolicyByName(name); err == nil {
+			t.Errorf("KeeperPolicyByName(%s) error = nil; want an error", name)
+		}
+	}
+}
+
+func TestServeWithKeeperPolicy(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"imports/2021/copy/beach.jpg": "beach",
+		"library/beach.jpg":           "beach",
+		"imports/beach.jpg":           "beach",
+		"library/dune.jpg":            "dune",
+	})
+
+	// Run a few times, the keeper must not depend on scheduling
+	for i := 0; i < 5; i++ {
+		policy := KeepInDirectory(filepath.Join(directory, "library"))
+		served := servePhotos(New(directory, 4, WithKeeperPolicy(policy, KeepShortestPath)))
+
+		if len(served) != 4 {
+			t.Fatalf("len(served) = %d; want 4", len(served))
+		}
+
+		for _, photoMetadata := range served {
+			relativePath, _ := filepath.Rel(directory, photoMetadata.Path)
+			switch relativePath {
+			case "library/beach.jpg", "library/dune.jpg":
+				if photoMetadata.DuplicatePath != "" {
+					t.Errorf("%s: DuplicatePath = %s; want empty", relativePath, photoMetadata.DuplicatePath)
+				}
+			default:
+				if photoMetadata.DuplicatePath != filepath.Join(directory, "library/beach.jpg") {
+					t.Errorf("%s: DuplicatePath = %s; want library/beach.jpg", relativePath, photoMetadata.DuplicatePath)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package deduplicator
+
+import (
+	"errors"
+	"image"
+	"os"
+	"photo-deduplicator/internal/exif"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Parse the EXIF metadata (capture time, camera, dimensions, orientation, GPS) of every
+// photo in the hashing workers and serve it as DedupeFileMetadata.Exif
+func WithExif() Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.readExif = true
+	}
+}
+
+// Read the metadata of a photo, nil when nothing could be read.
+// Images without EXIF dimensions get them from their header.
+func readMetadata(fileName string) *exif.Metadata {
+	metadata, err := exif.ReadFile(fileName)
+	if err != nil {
+		if !errors.Is(err, exif.ErrNoExif) {
+			log.Debug("Unable to read EXIF of ", fileName, " (", err, ")")
+		}
+		metadata = nil
+	}
+
+	if metadata != nil && metadata.Width != 0 && metadata.Height != 0 {
+		return metadata
+	}
+
+	width, height, err := imageSize(fileName)
+	if err != nil {
+		return metadata
+	}
+
+	if metadata == nil {
+		metadata = &exif.Metadata{}
+	}
+	metadata.Width, metadata.Height = width, height
+	return metadata
+}
+
+// Size of an image from its header, for the formats registered with the image package
+func imageSize(fileName string) (int, int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
@@ -0,0 +1,57 @@
+package deduplicator
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeWithExif(t *testing.T) {
+
+	directory := t.TempDir()
+
+	// Little endian TIFF with a single Make tag
+	tiff := []byte("II*\x00\x08\x00\x00\x00" +
+		"\x01\x00" + "\x0F\x01\x02\x00\x05\x00\x00\x00\x1A\x00\x00\x00" + "\x00\x00\x00\x00" +
+		"Sony\x00")
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testPhoto(64, 48), nil); err != nil {
+		t.Fatal(err)
+	}
+	photo := withJPEGSegment(t, encoded.Bytes(), 0xE1, append([]byte("Exif\x00\x00"), tiff...))
+	if err := os.WriteFile(filepath.Join(directory, "camera.jpg"), photo, 0640); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(directory, "export.png"), testPhoto(32, 24))
+
+	served := servePhotos(New(directory, 2, WithExif()))
+	if len(served) != 2 {
+		t.Fatalf("len(served) = %d; want 2", len(served))
+	}
+
+	for _, photoMetadata := range served {
+		if photoMetadata.Exif == nil {
+			t.Fatalf("%s: photoMetadata.Exif = nil; want metadata", photoMetadata.Path)
+		}
+		if photoMetadata.Size == 0 || photoMetadata.ModTime.IsZero() {
+			t.Errorf("%s: Size, ModTime = %d, %v; want both set", photoMetadata.Path, photoMetadata.Size, photoMetadata.ModTime)
+		}
+
+		switch filepath.Base(photoMetadata.Path) {
+		case "camera.jpg":
+			if photoMetadata.Exif.Make != "Sony" {
+				t.Errorf("camera.jpg: Exif.Make = %q; want Sony", photoMetadata.Exif.Make)
+			}
+			if photoMetadata.Exif.Width != 64 || photoMetadata.Exif.Height != 48 {
+				t.Errorf("camera.jpg: Exif size = %dx%d; want 64x48", photoMetadata.Exif.Width, photoMetadata.Exif.Height)
+			}
+		case "export.png":
+			if photoMetadata.Exif.Width != 32 || photoMetadata.Exif.Height != 24 {
+				t.Errorf("export.png: Exif size = %dx%d; want 32x24", photoMetadata.Exif.Width, photoMetadata.Exif.Height)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package deduplicator
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	// Register the decoders used for perceptual hashing
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Algorithm used to compute a 64 bit perceptual hash from decoded pixels
+type PerceptualAlgorithm int
+
+const (
+	// Perceptual hashing turned off
+	PerceptualNone PerceptualAlgorithm = iota
+	// Average hash, each bit is a pixel of an 8x8 thumbnail compared to the mean
+	AverageHash
+	// Difference hash, each bit compares neighbouring pixels of a 9x8 thumbnail
+	DifferenceHash
+	// DCT hash, each bit is a low frequency coefficient of a 32x32 thumbnail compared to the median
+	PerceptualHash
+)
+
+// Bits in a perceptual hash
+const perceptualHashBits = 64
+
+// Hamming distance used when no threshold is given
+const DefaultPerceptualThreshold = 10
+
+// Largest meaningful threshold, every photo is within it of every other photo
+const MaxPerceptualThreshold = perceptualHashBits
+
+func (algorithm PerceptualAlgorithm) String() string {
+	switch algorithm {
+	case PerceptualNone:
+		return "none"
+	case AverageHash:
+		return "ahash"
+	case DifferenceHash:
+		return "dhash"
+	case PerceptualHash:
+		return "phash"
+	}
+	return "unknown"
+}
+
+// Look up a perceptual algorithm by name (ahash, dhash or phash)
+func PerceptualAlgorithmByName(name string) (PerceptualAlgorithm, error) {
+	for _, algorithm := range []PerceptualAlgorithm{PerceptualNone, AverageHash, DifferenceHash, PerceptualHash} {
+		if algorithm.String() == strings.ToLower(name) {
+			return algorithm, nil
+		}
+	}
+	return PerceptualNone, fmt.Errorf("unknown perceptual algorithm %s", name)
+}
+
+// Also look for near duplicates: photos that are not byte identical but whose perceptual
+// hashes are within threshold bits of each other (resized, re-encoded or re-saved copies).
+func WithPerceptual(algorithm PerceptualAlgorithm, threshold int) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.perceptual = algorithm
+		deduplicator.perceptualThreshold = threshold
+	}
+}
+
+// Number of bits two perceptual hashes differ by
+func hammingDistance(first, second uint64) int {
+	return bits.OnesCount64(first ^ second)
+}
+
+// Similarity score between 0 and 1 for a hamming distance
+func similarity(distance int) float64 {
+	return 1 - float64(distance)/perceptualHashBits
+}
+
+// Decode an image file and compute its perceptual hash
+func perceptualHashPhoto(algorithm PerceptualAlgorithm, fileName string) (uint64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	photo, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return perceptualHash(algorithm, photo), nil
+}
+
+// Compute the perceptual hash of an image
+func perceptualHash(algorithm PerceptualAlgorithm, photo image.Image) uint64 {
+	switch algorithm {
+	case AverageHash:
+		return averageHash(photo)
+	case DifferenceHash:
+		return differenceHash(photo)
+	case PerceptualHash:
+		return dctHash(photo)
+	}
+	return 0
+}
+
+func averageHash(photo image.Image) uint64 {
+	pixels := grayThumbnail(photo, 8, 8)
+
+	mean := 0.0
+	for _, pixel := range pixels {
+		mean += pixel
+	}
+	mean /= float64(len(pixels))
+
+	var hash uint64
+	for i, pixel := range pixels {
+		if pixel > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+func differenceHash(photo image.Image) uint64 {
+	pixels := grayThumbnail(photo, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] < pixels[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+func dctHash(photo image.Image) uint64 {
+	const size = 32
+	pixels := grayThumbnail(photo, size, size)
+
+	// Separable 2D DCT-II, rows then columns
+	rows := make([]float64, size*size)
+	for y := 0; y < size; y++ {
+		copy(rows[y*size:], dct(pixels[y*size:(y+1)*size]))
+	}
+
+	coefficients := make([]float64, size*size)
+	column := make([]float64, size)
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			column[y] = rows[y*size+x]
+		}
+		for y, value := range dct(column) {
+			coefficients[y*size+x] = value
+		}
+	}
+
+	// Keep the lowest 8x8 frequencies
+	lowFrequencies := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		lowFrequencies = append(lowFrequencies, coefficients[y*size:y*size+8]...)
+	}
+
+	// The DC term is skipped when computing the median, it only carries brightness.
+	// That leaves 63 terms, an odd count, so the median is the middle one.
+	sorted := append([]float64(nil), lowFrequencies[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, value := range lowFrequencies {
+		if value > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// One dimensional DCT-II
+func dct(values []float64) []float64 {
+	n := len(values)
+	result := make([]float64, n)
+	for k := 0; k < n; k++ {
+		sum := 0.0
+		for i, value := range values {
+			sum += value * math.Cos(math.Pi/float64(n)*(float64(i)+0.5)*float64(k))
+		}
+		result[k] = sum
+	}
+	return result
+}
+
+// Shrink an image to width x height grayscale pixels by averaging every source
+// pixel falling in each cell. Returned row by row.
+func grayThumbnail(photo image.Image, width, height int) []float64 {
+	bounds := photo.Bounds()
+	sums := make([]float64, width*height)
+	counts := make([]int, width*height)
+
+	// JPEGs decode to YCbCr, the Y plane already is the luminance
+	luminance := func(x, y int) float64 {
+		r, g, b, _ := photo.At(x, y).RGBA()
+		return (299*float64(r) + 587*float64(g) + 114*float64(b)) / 1000 / 257
+	}
+	if ycbcr, ok := photo.(*image.YCbCr); ok {
+		luminance = func(x, y int) float64 {
+			return float64(ycbcr.Y[ycbcr.YOffset(x, y)])
+		}
+	} else if gray, ok := photo.(*image.Gray); ok {
+		luminance = func(x, y int) float64 {
+			return float64(gray.Pix[gray.PixOffset(x, y)])
+		}
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cellY := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cellX := (x - bounds.Min.X) * width / bounds.Dx()
+			sums[cellY*width+cellX] += luminance(x, y)
+			counts[cellY*width+cellX]++
+		}
+	}
+
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+
+	return sums
+}
+
+// Perceptual hashes of every photo that was not a near duplicate, kept in a BK-tree
+// so near duplicate lookups stay sub-linear as the library grows
+type perceptualIndex struct {
+	lock sync.Mutex
+	tree bkTree
+}
+
+// Find the closest photo within threshold bits of hash. When there is none the
+// photo is added to the index. Done under one lock so two similar photos processed
+// at the same time still find each other.
+func (index *perceptualIndex) matchOrAdd(hash uint64, path string, threshold int) (string, int, bool) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	matches := index.tree.within(hash, threshold)
+	if len(matches) == 0 {
+		index.tree.add(hash, path)
+		return "", 0, false
+	}
+
+	// Closest match wins, ties go to the lowest path so the result doesn't depend on tree order
+	closest := matches[0]
+	for _, match := range matches[1:] {
+		if match.distance < closest.distance || (match.distance == closest.distance && match.path < closest.path) {
+			closest = match
+		}
+	}
+
+	return closest.path, closest.distance, true
+}
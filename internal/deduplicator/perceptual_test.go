@@ -0,0 +1,144 @@
+package deduplicator
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Smooth diagonal gradient with a bright square, scaled to width x height
+func testPhoto(width, height int) image.Image {
+	photo := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8((x*255/width + y*255/height) / 2)
+			if x > width/4 && x < width/2 && y > height/4 && y < height/2 {
+				value = 255
+			}
+			photo.Set(x, y, color.RGBA{value, value / 2, 255 - value, 255})
+		}
+	}
+	return photo
+}
+
+// Checkerboard, nothing like testPhoto
+func testOtherPhoto(width, height int) image.Image {
+	photo := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x/(width/4)+y/(height/4))%2 == 0 {
+				photo.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return photo
+}
+
+func writeJPEG(t *testing.T, path string, photo image.Image, quality int) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, photo, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePNG(t *testing.T, path string, photo image.Image) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, photo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+
+	original := testPhoto(256, 192)
+	resized := testPhoto(128, 96)
+	other := testOtherPhoto(256, 192)
+
+	for _, algorithm := range []PerceptualAlgorithm{AverageHash, DifferenceHash, PerceptualHash} {
+		originalHash := perceptualHash(algorithm, original)
+
+		if distance := hammingDistance(originalHash, perceptualHash(algorithm, resized)); distance > DefaultPerceptualThreshold {
+			t.Errorf("%s distance(original, resized) = %d; want <= %d", algorithm, distance, DefaultPerceptualThreshold)
+		}
+
+		if distance := hammingDistance(originalHash, perceptualHash(algorithm, other)); distance <= DefaultPerceptualThreshold {
+			t.Errorf("%s distance(original, other) = %d; want > %d", algorithm, distance, DefaultPerceptualThreshold)
+		}
+	}
+}
+
+func TestDCTHashMedian(t *testing.T) {
+
+	// Uneven pattern so no two low frequencies are the same
+	photo := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			photo.SetGray(x, y, color.Gray{uint8((x*x*3 + y*7 + x*y) % 251)})
+		}
+	}
+
+	// Half of the 63 terms after the DC term are above their median, the median itself is not
+	hash := dctHash(photo)
+	if above := bits.OnesCount64(hash &^ 1); above != 31 {
+		t.Errorf("dctHash() = %016x, %d terms above the median; want 31", hash, above)
+	}
+}
+
+func TestPerceptualAlgorithmByName(t *testing.T) {
+
+	algorithm, err := PerceptualAlgorithmByName("pHash")
+	if err != nil || algorithm != PerceptualHash {
+		t.Errorf("PerceptualAlgorithmByName(pHash) = %s, %v; want phash, nil", algorithm, err)
+	}
+
+	if _, err := PerceptualAlgorithmByName("bhash"); err == nil {
+		t.Errorf("PerceptualAlgorithmByName(bhash) error = nil; want error")
+	}
+}
+
+func TestServeNearDuplicates(t *testing.T) {
+
+	directory := t.TempDir()
+	writePNG(t, filepath.Join(directory, "original.png"), testPhoto(256, 192))
+	writeJPEG(t, filepath.Join(directory, "resaved.jpg"), testPhoto(128, 96), 60)
+	writePNG(t, filepath.Join(directory, "other.png"), testOtherPhoto(256, 192))
+	writePhotos(t, directory, map[string]string{"notes.txt": "not a photo"})
+
+	served := servePhotos(New(directory, 2, WithPerceptual(PerceptualHash, DefaultPerceptualThreshold)))
+
+	nearDuplicates := 0
+	for _, photoMetadata := range served {
+		if photoMetadata.Status != StatusNearDuplicate {
+			continue
+		}
+		nearDuplicates++
+
+		pair := filepath.Base(photoMetadata.Path) + " " + filepath.Base(photoMetadata.SimilarPath)
+		if pair != "original.png resaved.jpg" && pair != "resaved.jpg original.png" {
+			t.Errorf("near duplicate = %s; want original.png and resaved.jpg", pair)
+		}
+
+		if photoMetadata.Similarity <= 0 || photoMetadata.Similarity > 1 {
+			t.Errorf("photoMetadata.Similarity = %f; want between 0 and 1", photoMetadata.Similarity)
+		}
+	}
+
+	if nearDuplicates != 1 {
+		t.Errorf("nearDuplicates = %d; want 1", nearDuplicates)
+	}
+}
@@ -0,0 +1,73 @@
+package deduplicator
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Treat these directories as a read-only reference library. Every reference file is matched before
+// anything else, so a copy found in the other roots is always a duplicate of the reference copy.
+// Reference files are never served, duplicates among them are left alone.
+func WithReference(directories ...string) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.references = append(deduplicator.references, directories...)
+	}
+}
+
+// Check if a path is inside of one of the reference directories
+func (deduplicator *PhotoDeduplicator) InReference(path string) bool {
+	if len(deduplicator.references) == 0 || path == "" {
+		return false
+	}
+	owner := rootOf(append(append([]string{}, deduplicator.references...), deduplicator.Roots()...), path)
+	for _, reference := range deduplicator.references {
+		if owner == reference {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk and match every reference file, waiting until all of them are in the index.
+// Results are only recorded in the snapshot.
+func (deduplicator *PhotoDeduplicator) matchReferences(ctx context.Context, allRoots []string, index *candidateIndex, similarIndex *perceptualIndex) {
+	photoChannel := make(chan string, deduplicator.bufferSize)
+	keyValueChannel := make(chan pair, deduplicator.bufferSize)
+	discardChannel := make(chan DedupeFileMetadata, deduplicator.bufferSize)
+
+	var photoWaitGroup sync.WaitGroup
+	photoWaitGroup.Add(deduplicator.hashingRoutines)
+	fileChannel := make(chan walkedFile, deduplicator.bufferSize)
+	go numberPhotos(photoChannel, fileChannel)
+	for i := 0; i < deduplicator.hashingRoutines; i++ {
+		go deduplicator.processPhoto(ctx, i, deduplicator.references, index, similarIndex, fileChannel, keyValueChannel, &photoWaitGroup)
+	}
+
+	var hashingWaitGroup sync.WaitGroup
+	hashingWaitGroup.Add(1)
+	go checkCollision(keyValueChannel, discardChannel, deduplicator.snapshot.served, &hashingWaitGroup)
+	go func() {
+		for range discardChannel {
+		}
+	}()
+
+	log.Info("Iterate through reference photos")
+	for _, reference := range deduplicator.references {
+		if err := walkRoot(ctx, reference, nestedRoots(allRoots, reference), deduplicator.filter, deduplicator.skipped, photoChannel); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Error("Error walking reference photos in ", reference, ", stopping early")
+			log.Error(err)
+		}
+	}
+	close(photoChannel)
+
+	photoWaitGroup.Wait()
+	close(keyValueChannel)
+	hashingWaitGroup.Wait()
+	close(discardChannel)
+	log.Info("Reference photos matched")
+}
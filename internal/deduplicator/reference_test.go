@@ -0,0 +1,56 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReference(t *testing.T) {
+
+	directory := t.TempDir()
+	archive := filepath.Join(directory, "archive")
+	incoming := filepath.Join(directory, "i")
+	writePhotos(t, directory, map[string]string{
+		"archive/2019/a.jpg":      "photo a",
+		"archive/2019/copy/a.jpg": "photo a",
+		"archive/2020/b.jpg":      "photo bb",
+		"i/a.jpg":                 "photo a",
+		"i/b.jpg":                 "photo bb",
+		"i/b2.jpg":                "photo bb",
+		"i/c.jpg":                 "photo c",
+	})
+
+	// The shortest path would otherwise be an incoming copy
+	deduplicator := New(incoming, 2, WithReference(archive), WithKeeperPolicy(KeepShortestPath))
+	served := make(map[string]DedupeFileMetadata)
+	for _, photoMetadata := range servePhotos(deduplicator) {
+		served[photoMetadata.Path] = photoMetadata
+	}
+
+	if len(served) != 4 {
+		t.Errorf("served %d files; want the 4 incoming files", len(served))
+	}
+
+	if keeper := served[filepath.Join(incoming, "a.jpg")].DuplicatePath; !deduplicator.InReference(keeper) {
+		t.Errorf("a.jpg DuplicatePath = %s; want one of the archive copies", keeper)
+	}
+
+	want := map[string]string{
+		"b.jpg":  filepath.Join(archive, "2020", "b.jpg"),
+		"b2.jpg": filepath.Join(archive, "2020", "b.jpg"),
+		"c.jpg":  "",
+	}
+	for name, keeper := range want {
+		path := filepath.Join(incoming, name)
+		if served[path].DuplicatePath != keeper {
+			t.Errorf("%s DuplicatePath = %s; want %s", name, served[path].DuplicatePath, keeper)
+		}
+	}
+
+	if !deduplicator.InReference(filepath.Join(archive, "2019", "a.jpg")) {
+		t.Errorf("InReference(archive/2019/a.jpg) = false; want true")
+	}
+	if deduplicator.InReference(filepath.Join(incoming, "a.jpg")) {
+		t.Errorf("InReference(i/a.jpg) = true; want false")
+	}
+}
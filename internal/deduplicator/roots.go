@@ -0,0 +1,87 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Also deduplicate these directories. Roots are in order of precedence: the directory passed to New
+// comes first, then these in order. When copies span roots the one in the earliest root is kept,
+// ahead of any keeper policy. Copies left alone by an incremental scan stay keepers.
+// A root nested in another root is only walked as itself.
+func WithRoots(directories ...string) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.roots = append(deduplicator.roots, directories...)
+	}
+}
+
+// Every root in order of precedence
+func (deduplicator *PhotoDeduplicator) Roots() []string {
+	roots := []string{}
+	if deduplicator.directory != "" {
+		roots = append(roots, deduplicator.directory)
+	}
+	return append(roots, deduplicator.roots...)
+}
+
+// Root a walked path belongs to, the innermost one when roots are nested
+func rootOf(roots []string, path string) string {
+	absolute := absolutePath(path)
+	found, foundLength := "", -1
+	for _, root := range roots {
+		absoluteRoot := absolutePath(root)
+		if !isWithin(absoluteRoot, absolute) {
+			continue
+		}
+		if len(absoluteRoot) > foundLength {
+			found, foundLength = root, len(absoluteRoot)
+		}
+	}
+	return found
+}
+
+// Check if path is the same as or nested inside of directory, both absolute
+func isWithin(directory, path string) bool {
+	relativePath, err := filepath.Rel(directory, path)
+	if err != nil {
+		return false
+	}
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// Other roots nested inside of root, they are walked on their own
+func nestedRoots(roots []string, root string) map[string]bool {
+	nested := make(map[string]bool)
+	absoluteRoot := absolutePath(root)
+	for _, other := range roots {
+		absoluteOther := absolutePath(other)
+		if absoluteOther != absoluteRoot && isWithin(absoluteRoot, absoluteOther) {
+			nested[absoluteOther] = true
+		}
+	}
+	return nested
+}
+
+// Keep the copy in the earliest root
+func keepInRootOrder(roots []string) KeeperPolicy {
+	precedence := make(map[string]int, len(roots))
+	for i, root := range roots {
+		if _, ok := precedence[root]; !ok {
+			precedence[root] = i
+		}
+	}
+
+	rank := func(photoMetadata *DedupeFileMetadata) int {
+		if i, ok := precedence[photoMetadata.Root]; ok {
+			return i
+		}
+		return len(roots)
+	}
+
+	return KeeperPolicy{
+		name: "root-order",
+		compare: func(first, second *DedupeFileMetadata) int {
+			return compareInts(rank(first), rank(second))
+		},
+	}
+}
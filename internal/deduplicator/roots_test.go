@@ -0,0 +1,55 @@
+package deduplicator
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRootPrecedence(t *testing.T) {
+
+	directory := t.TempDir()
+	nas := filepath.Join(directory, "nas")
+	laptop := filepath.Join(directory, "laptop")
+	sdcard := filepath.Join(nas, "import", "sdcard")
+	writePhotos(t, directory, map[string]string{
+		// Shorter path, would be found first by most walks
+		"laptop/a.jpg":            "photo a",
+		"nas/2021/a.jpg":          "photo a",
+		"nas/import/sdcard/a.jpg": "photo a",
+		"nas/import/sdcard/b.jpg": "photo b",
+		"laptop/b.jpg":            "photo b",
+		"laptop/c.jpg":            "photo c",
+	})
+
+	deduplicator := New(nas, 2, WithRoots(laptop, sdcard), WithKeeperPolicy(KeepShortestPath))
+	served := make(map[string]DedupeFileMetadata)
+	for _, photoMetadata := range servePhotos(deduplicator) {
+		served[photoMetadata.Path] = photoMetadata
+	}
+
+	if len(served) != 6 {
+		t.Fatalf("len(served) = %d; want 6, nested roots must only be walked once", len(served))
+	}
+
+	wantKeepers := map[string]string{
+		filepath.Join(laptop, "a.jpg"): filepath.Join(nas, "2021", "a.jpg"),
+		filepath.Join(sdcard, "a.jpg"): filepath.Join(nas, "2021", "a.jpg"),
+		filepath.Join(sdcard, "b.jpg"): filepath.Join(laptop, "b.jpg"),
+	}
+	for path, keeper := range wantKeepers {
+		if served[path].DuplicatePath != keeper {
+			t.Errorf("%s DuplicatePath = %s; want %s", path, served[path].DuplicatePath, keeper)
+		}
+	}
+
+	wantRoots := map[string]string{
+		filepath.Join(nas, "2021", "a.jpg"): nas,
+		filepath.Join(laptop, "c.jpg"):      laptop,
+		filepath.Join(sdcard, "b.jpg"):      sdcard,
+	}
+	for path, root := range wantRoots {
+		if served[path].Root != root {
+			t.Errorf("%s Root = %s; want %s", path, served[path].Root, root)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package deduplicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the snapshot file format
+const SnapshotVersion = 1
+
+// A file as it was at the end of a run
+type SnapshotFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Device  uint64    `json:"device,omitempty"`
+	Inode   uint64    `json:"inode,omitempty"`
+	// Empty when the file never had to be hashed
+	Hash string `json:"hash,omitempty"`
+	// Keeper of the file's contents, empty when the file is the keeper
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+}
+
+// Every file seen by a run, so the next run over the same directory only has to look at what changed.
+// Hashes are only comparable between runs using the same algorithm and content mode.
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Directory string    `json:"directory"`
+	Roots     []string  `json:"roots,omitempty"`
+	// See WithReference
+	References []string       `json:"references,omitempty"`
+	Algorithm  string         `json:"algorithm"`
+	Content    bool           `json:"content"`
+	Files      []SnapshotFile `json:"files"`
+}
+
+// How the tree changed since the previous snapshot, see WithIncremental
+type Changes struct {
+	New      []string
+	Modified []string
+	Deleted  []string
+	// Previous paths by new path
+	Moved map[string]string
+}
+
+// Read a snapshot written by Save
+func LoadSnapshot(path string) (*Snapshot, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(contents, snapshot); err != nil {
+		return nil, fmt.Errorf("unable to parse snapshot %s: %w", path, err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d in %s", snapshot.Version, path)
+	}
+	return snapshot, nil
+}
+
+// Write the snapshot to a file, replacing it in one rename
+func (snapshot *Snapshot) Save(path string) error {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Only look at files that are new or changed since the previous snapshot of the same directory.
+// Unchanged keepers are still matched against, but only new, modified and moved files are served.
+// Near duplicates are only looked for among the served files.
+// A snapshot made with another algorithm or content mode is ignored and everything is scanned.
+func WithIncremental(previous *Snapshot) Option {
+	return func(deduplicator *PhotoDeduplicator) {
+		deduplicator.previous = previous
+	}
+}
+
+// Every file of the last scan, including the files an incremental scan left alone.
+// Complete once all photos have been served.
+func (deduplicator *PhotoDeduplicator) Snapshot() *Snapshot {
+	snapshot := deduplicator.snapshot.build(deduplicator.directory, deduplicator.roots, deduplicator.hasher.Name(), deduplicator.contentHashing)
+	snapshot.References = deduplicator.references
+	return snapshot
+}
+
+// How the tree changed since the previous snapshot, empty unless WithIncremental is set.
+// Complete once all photos have been served.
+func (deduplicator *PhotoDeduplicator) Changes() Changes {
+	if deduplicator.incremental == nil {
+		return Changes{Moved: map[string]string{}}
+	}
+	return deduplicator.incremental.changes()
+}
+
+// Files of the current scan, by path
+type snapshotIndex struct {
+	lock  sync.Mutex
+	files map[string]*SnapshotFile
+}
+
+func newSnapshotIndex() *snapshotIndex {
+	return &snapshotIndex{files: make(map[string]*SnapshotFile)}
+}
+
+// Record a file as the hashing workers found it
+func (index *snapshotIndex) observe(fileName string, info os.FileInfo) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.files[fileName] = newSnapshotFile(fileName, info)
+}
+
+// Record a file carried over from the previous snapshot
+func (index *snapshotIndex) carry(file SnapshotFile) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.files[file.Path] = &file
+}
+
+// Record what was found out about a served file
+func (index *snapshotIndex) served(photoMetadata *DedupeFileMetadata) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	file, ok := index.files[photoMetadata.Path]
+	if !ok {
+		// Could not be read, it will be looked at again next time
+		return
+	}
+	file.Hash = photoMetadata.Hash
+	file.DuplicateOf = photoMetadata.DuplicatePath
+}
+
+func (index *snapshotIndex) build(directory string, roots []string, algorithm string, content bool) *Snapshot {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		Created:   time.Now(),
+		Directory: directory,
+		Roots:     roots,
+		Algorithm: algorithm,
+		Content:   content,
+		Files:     make([]SnapshotFile, 0, len(index.files)),
+	}
+	for _, file := range index.files {
+		snapshot.Files = append(snapshot.Files, *file)
+	}
+	sort.Slice(snapshot.Files, func(i, j int) bool {
+		return snapshot.Files[i].Path < snapshot.Files[j].Path
+	})
+	return snapshot
+}
+
+func newSnapshotFile(fileName string, info os.FileInfo) *SnapshotFile {
+	device, inode := fileIdentity(info)
+	return &SnapshotFile{
+		Path:    fileName,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Device:  device,
+		Inode:   inode,
+	}
+}
+
+// Check the snapshot still describes the file. The device is left out since it changes
+// when a NAS or USB drive is mounted again, a file found on another device takes the new one.
+func (file *SnapshotFile) matches(info os.FileInfo) bool {
+	device, inode := fileIdentity(info)
+	if file.Size != info.Size() || !file.ModTime.Equal(info.ModTime()) || file.Inode != inode {
+		return false
+	}
+	if file.Device != device {
+		log.Debug(file.Path, " moved from device ", file.Device, " to ", device, ", assuming it was remounted")
+		file.Device = device
+	}
+	return true
+}
+
+// A file that is the same file even after a rename
+type fileKey struct {
+	size    int64
+	modTime int64
+	inode   uint64
+}
+
+func (file *SnapshotFile) key() fileKey {
+	return fileKey{file.Size, file.ModTime.UnixNano(), file.Inode}
+}
+
+// Diff of the current tree against the previous snapshot
+type incrementalScan struct {
+	lock     sync.Mutex
+	previous map[string]SnapshotFile
+	// Previous files that are gone from their path, they were either moved or deleted
+	missing map[string]SnapshotFile
+	// Paths of missing files by what identifies them after a rename, only with inodes
+	missingKeys map[fileKey]string
+	// Paths of missing files claimed by a move
+	moved    map[string]string
+	new      []string
+	modified []string
+}
+
+// Compare the previous snapshot with the filesystem before anything is walked.
+// Unchanged files are carried into snapshot and unchanged keepers seeded into index,
+// so new files are matched against them. When a keeper is gone or changed, the first
+// unchanged duplicate of it takes its place.
+// Returns nil when the previous snapshot can not be used for this run.
+func newIncrementalScan(previous *Snapshot, deduplicator *PhotoDeduplicator, index *candidateIndex, snapshot *snapshotIndex) *incrementalScan {
+	if previous.Algorithm != deduplicator.hasher.Name() || previous.Content != deduplicator.contentHashing {
+		log.Warn("Previous snapshot was made with other hash settings, scanning everything")
+		return nil
+	}
+	previousRoots := previous.Roots
+	if previous.Directory != "" {
+		previousRoots = append([]string{previous.Directory}, previousRoots...)
+	}
+	if !sameRoots(previousRoots, deduplicator.Roots()) || !sameRoots(previous.References, deduplicator.references) {
+		log.Warn("Previous snapshot is of ", previous.Directory, ", scanning everything")
+		return nil
+	}
+
+	scan := &incrementalScan{
+		previous:    make(map[string]SnapshotFile, len(previous.Files)),
+		missing:     make(map[string]SnapshotFile),
+		missingKeys: make(map[fileKey]string),
+		moved:       make(map[string]string),
+	}
+
+	unchanged := make(map[string]SnapshotFile)
+	for _, file := range previous.Files {
+		scan.previous[file.Path] = file
+
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			scan.missing[file.Path] = file
+			if file.Inode != 0 {
+				scan.missingKeys[file.key()] = file.Path
+			}
+			continue
+		}
+		if file.matches(info) {
+			unchanged[file.Path] = file
+		}
+	}
+
+	// Keepers that are gone or changed are replaced by their first unchanged duplicate
+	paths := make([]string, 0, len(unchanged))
+	for path := range unchanged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	promoted := make(map[string]string)
+	for _, path := range paths {
+		file := unchanged[path]
+		if file.DuplicateOf == "" {
+			continue
+		}
+		if _, ok := unchanged[file.DuplicateOf]; ok {
+			continue
+		}
+		if keeper, ok := promoted[file.DuplicateOf]; ok {
+			file.DuplicateOf = keeper
+		} else {
+			log.Debug(path, " replaces ", file.DuplicateOf, " as keeper")
+			promoted[file.DuplicateOf] = path
+			file.DuplicateOf = ""
+		}
+		unchanged[path] = file
+	}
+
+	for _, path := range paths {
+		file := unchanged[path]
+		snapshot.carry(file)
+		if file.DuplicateOf == "" {
+			index.seed(&candidate{path: file.Path, size: file.Size, fullHash: file.Hash})
+		}
+	}
+
+	return scan
+}
+
+func sameRoots(previous, current []string) bool {
+	if len(previous) != len(current) {
+		return false
+	}
+	for i := range previous {
+		if filepath.Clean(previous[i]) != filepath.Clean(current[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// What to do with a walked file, based on its current info.
+// Returns false when the file is unchanged and can be left alone, otherwise the hash
+// of the file when it is known to be a moved file.
+func (scan *incrementalScan) classify(fileName string, info os.FileInfo) (string, bool) {
+	scan.lock.Lock()
+	defer scan.lock.Unlock()
+
+	if file, ok := scan.previous[fileName]; ok {
+		if file.matches(info) {
+			return "", false
+		}
+		scan.modified = append(scan.modified, fileName)
+		return "", true
+	}
+
+	current := newSnapshotFile(fileName, info)
+	if path, ok := scan.missingKeys[current.key()]; ok && current.Inode != 0 {
+		file := scan.missing[path]
+		delete(scan.missingKeys, current.key())
+		delete(scan.missing, path)
+		scan.moved[fileName] = path
+		return file.Hash, true
+	}
+
+	scan.new = append(scan.new, fileName)
+	return "", true
+}
+
+func (scan *incrementalScan) changes() Changes {
+	scan.lock.Lock()
+	defer scan.lock.Unlock()
+
+	changes := Changes{
+		New:      append([]string{}, scan.new...),
+		Modified: append([]string{}, scan.modified...),
+		Deleted:  []string{},
+		Moved:    make(map[string]string, len(scan.moved)),
+	}
+	for path, previous := range scan.moved {
+		changes.Moved[path] = previous
+	}
+	for _, file := range scan.missing {
+		changes.Deleted = append(changes.Deleted, file.Path)
+	}
+	sort.Strings(changes.New)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+	return changes
+}
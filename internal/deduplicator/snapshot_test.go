@@ -0,0 +1,153 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIncremental(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"keeper.jpg":   "photo a",
+		"edited.jpg":   "photo b",
+		"moving.jpg":   "photo c",
+		"deleted.jpg":  "photo d",
+		"deleted2.jpg": "photo d",
+	})
+
+	first := New(directory, 2, WithFullHashing())
+	if served := servePhotos(first); len(served) != 5 {
+		t.Fatalf("first run len(served) = %d; want 5", len(served))
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := first.Snapshot().Save(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := LoadSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous.Files) != 5 {
+		t.Fatalf("len(previous.Files) = %d; want 5", len(previous.Files))
+	}
+
+	// One of each change
+	writePhotos(t, directory, map[string]string{"import/copy.jpg": "photo a"})
+	edited := filepath.Join(directory, "edited.jpg")
+	if err := os.WriteFile(edited, []byte("photo B"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(edited, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(directory, "import", "moved.jpg")
+	if err := os.Rename(filepath.Join(directory, "moving.jpg"), moved); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"deleted.jpg", "deleted2.jpg"} {
+		if err := os.Remove(filepath.Join(directory, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	second := New(directory, 2, WithFullHashing(), WithIncremental(previous))
+	served := make(map[string]DedupeFileMetadata)
+	for _, photoMetadata := range servePhotos(second) {
+		served[photoMetadata.Path] = photoMetadata
+	}
+
+	copyPath := filepath.Join(directory, "import", "copy.jpg")
+	if len(served) != 3 {
+		t.Errorf("second run served %v; want copy.jpg, edited.jpg and moved.jpg", served)
+	}
+	if keeper := filepath.Join(directory, "keeper.jpg"); served[copyPath].DuplicatePath != keeper {
+		t.Errorf("copy.jpg DuplicatePath = %s; want %s", served[copyPath].DuplicatePath, keeper)
+	}
+
+	// The unchanged keeper is part of the group even though it was not served
+	if groups := second.Groups(); len(groups) != 1 || len(groups[0].Paths) != 2 {
+		t.Errorf("Groups() = %+v; want keeper.jpg and copy.jpg", groups)
+	}
+
+	changes := second.Changes()
+	want := Changes{
+		New:      []string{copyPath},
+		Modified: []string{edited},
+		Deleted:  []string{filepath.Join(directory, "deleted.jpg"), filepath.Join(directory, "deleted2.jpg")},
+		Moved:    map[string]string{moved: filepath.Join(directory, "moving.jpg")},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes() = %+v; want %+v", changes, want)
+	}
+
+	// Unchanged files are carried over
+	snapshot := second.Snapshot()
+	if len(snapshot.Files) != 4 {
+		t.Errorf("len(Snapshot().Files) = %d; want 4", len(snapshot.Files))
+	}
+}
+
+func TestIncrementalRemounted(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{
+		"a.jpg":       "photo a",
+		"b.jpg":       "photo b",
+		"album/a.jpg": "photo a",
+	})
+
+	first := New(directory, 2, WithFullHashing())
+	servePhotos(first)
+	previous := first.Snapshot()
+
+	// Mounting the photos again gives them another device number
+	for i := range previous.Files {
+		previous.Files[i].Device++
+	}
+	// and a rename while unmounted is still a move
+	moved := filepath.Join(directory, "c.jpg")
+	if err := os.Rename(filepath.Join(directory, "b.jpg"), moved); err != nil {
+		t.Fatal(err)
+	}
+
+	second := New(directory, 2, WithFullHashing(), WithIncremental(previous))
+	served := servePhotos(second)
+	if len(served) != 1 || served[0].Path != moved {
+		t.Errorf("second run served %+v; want c.jpg", served)
+	}
+
+	changes := second.Changes()
+	want := Changes{
+		New:      []string{},
+		Modified: []string{},
+		Deleted:  []string{},
+		Moved:    map[string]string{moved: filepath.Join(directory, "b.jpg")},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Changes() = %+v; want %+v", changes, want)
+	}
+}
+
+func TestIncrementalOtherSettings(t *testing.T) {
+
+	directory := t.TempDir()
+	writePhotos(t, directory, map[string]string{"a.jpg": "photo a", "b.jpg": "photo b"})
+
+	first := New(directory, 2)
+	servePhotos(first)
+
+	// Hashes of another algorithm can not be reused
+	second := New(directory, 2, WithHasher(SHA1), WithIncremental(first.Snapshot()))
+	if served := servePhotos(second); len(served) != 2 {
+		t.Errorf("len(served) = %d; want 2", len(served))
+	}
+	if changes := second.Changes(); len(changes.New) != 0 {
+		t.Errorf("Changes().New = %v; want empty", changes.New)
+	}
+}
@@ -0,0 +1,57 @@
+package deduplicator
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// Size of the chunks compared at a time when verifying files
+const verifyChunkSize = 64 * 1024
+
+// Compare two files byte for byte
+func compareFiles(firstName, secondName string) (bool, error) {
+	first, err := os.Open(firstName)
+	if err != nil {
+		return false, err
+	}
+	defer first.Close()
+
+	second, err := os.Open(secondName)
+	if err != nil {
+		return false, err
+	}
+	defer second.Close()
+
+	return compareReaders(bufio.NewReaderSize(first, verifyChunkSize), bufio.NewReaderSize(second, verifyChunkSize))
+}
+
+// Compare two streams until one of them ends
+func compareReaders(first, second io.Reader) (bool, error) {
+	firstChunk := make([]byte, verifyChunkSize)
+	secondChunk := make([]byte, verifyChunkSize)
+
+	for {
+		firstCount, firstErr := io.ReadFull(first, firstChunk)
+		secondCount, secondErr := io.ReadFull(second, secondChunk)
+
+		if !bytes.Equal(firstChunk[:firstCount], secondChunk[:secondCount]) {
+			return false, nil
+		}
+
+		firstDone := firstErr == io.EOF || firstErr == io.ErrUnexpectedEOF
+		secondDone := secondErr == io.EOF || secondErr == io.ErrUnexpectedEOF
+
+		if firstErr != nil && !firstDone {
+			return false, firstErr
+		}
+		if secondErr != nil && !secondDone {
+			return false, secondErr
+		}
+
+		if firstDone || secondDone {
+			return firstDone == secondDone, nil
+		}
+	}
+}
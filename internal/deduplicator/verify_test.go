@@ -0,0 +1,36 @@
+package deduplicator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompareReaders(t *testing.T) {
+
+	long := strings.Repeat("x", 3*verifyChunkSize)
+
+	tests := []struct {
+		first, second string
+		want          bool
+	}{
+		{"", "", true},
+		{"photo", "photo", true},
+		{"photo", "photos", false},
+		{"photo", "phot0", false},
+		{long, long, true},
+		{long, long + "x", false},
+		{long + "a", long + "b", false},
+	}
+
+	for _, test := range tests {
+		identical, err := compareReaders(bytes.NewReader([]byte(test.first)), bytes.NewReader([]byte(test.second)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identical != test.want {
+			t.Errorf("compareReaders(%d bytes, %d bytes) = %t; want %t", len(test.first), len(test.second), identical, test.want)
+		}
+	}
+}
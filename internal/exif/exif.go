@@ -0,0 +1,412 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Returned when a file carries no EXIF data
+var ErrNoExif = errors.New("no exif data")
+
+// Tags read from the EXIF data
+const (
+	tagImageWidth         = 0x0100
+	tagImageLength        = 0x0101
+	tagMake               = 0x010F
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifPointer        = 0x8769
+	tagGPSPointer         = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagPixelXDimension    = 0xA002
+	tagPixelYDimension    = 0xA003
+)
+
+// Tags of the GPS IFD, numbered separately from the others
+const (
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// Layout of EXIF date times
+const dateTimeLayout = "2006:01:02 15:04:05"
+
+// Largest EXIF block read from a JPEG APP1 segment
+const maxSegmentLength = 64 * 1024
+
+// Metadata parsed from the EXIF data of a photo.
+// Fields the photo doesn't record are left at their zero value.
+type Metadata struct {
+	// When the photo was taken, zero when unknown. Local time unless the
+	// camera recorded a UTC offset.
+	CaptureTime time.Time
+	// Camera manufacturer and model
+	Make  string
+	Model string
+	// Size in pixels, before applying the orientation
+	Width  int
+	Height int
+	// EXIF orientation (1 to 8), 0 when missing
+	Orientation int
+	// Where the photo was taken, nil when it has no location
+	GPS *Location
+}
+
+// Coordinates in decimal degrees, negative for south and west
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Read the EXIF metadata of a JPEG, PNG or TIFF based (including most RAW formats) file
+func ReadFile(path string) (*Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tiff, err := findTIFF(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTIFF(tiff)
+}
+
+// Locate the TIFF structure holding the EXIF data of a file
+func findTIFF(file *os.File) (io.ReaderAt, error) {
+	reader := bufio.NewReader(file)
+	header, _ := reader.Peek(8)
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return findJPEGExif(reader)
+	case bytes.HasPrefix(header, []byte{0x89, 'P', 'N', 'G'}):
+		return findPNGExif(reader)
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")),
+		bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIU\x00")):
+		// TIFF based files are the TIFF structure
+		return file, nil
+	}
+
+	return nil, ErrNoExif
+}
+
+// Find the APP1 segment holding the EXIF data of a JPEG
+func findJPEGExif(reader *bufio.Reader) (io.ReaderAt, error) {
+	if _, err := reader.Discard(2); err != nil {
+		return nil, err
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(reader, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+
+		// Start of scan or end of image, metadata always comes before
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, ErrNoExif
+		}
+
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(reader, lengthBytes[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(lengthBytes[:])) - 2
+		if length < 0 {
+			return nil, ErrNoExif
+		}
+
+		if marker[1] != 0xE1 {
+			if _, err := reader.Discard(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return nil, err
+		}
+
+		// APP1 also holds XMP, only the Exif one is wanted
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return bytes.NewReader(segment[6:]), nil
+		}
+	}
+}
+
+// Find the eXIf chunk of a PNG
+func findPNGExif(reader *bufio.Reader) (io.ReaderAt, error) {
+	if _, err := reader.Discard(8); err != nil {
+		return nil, err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		// EXIF has to come before the image data
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return nil, ErrNoExif
+		}
+
+		if chunkType == "eXIf" {
+			if length > maxSegmentLength {
+				return nil, ErrNoExif
+			}
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(chunk), nil
+		}
+
+		if _, err := reader.Discard(length + 4); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parse the TIFF structure and pull out the metadata
+func parseTIFF(tiff io.ReaderAt) (*Metadata, error) {
+	header := make([]byte, 8)
+	if _, err := tiff.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+
+	parser := &tiffParser{reader: tiff, order: order}
+
+	tags, err := parser.readIFD(int64(order.Uint32(header[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	if exifOffset, ok := tags[tagExifPointer]; ok {
+		exifTags, err := parser.readIFD(int64(exifOffset.uint(0)))
+		if err == nil {
+			for tag, value := range exifTags {
+				tags[tag] = value
+			}
+		}
+	}
+
+	metadata := &Metadata{
+		CaptureTime: captureTime(tags),
+		Make:        strings.TrimSpace(tags[tagMake].string()),
+		Model:       strings.TrimSpace(tags[tagModel].string()),
+		Orientation: int(tags[tagOrientation].uint(0)),
+	}
+
+	// The Exif IFD holds the size of the main image, IFD0 of a TIFF may only describe a thumbnail
+	metadata.Width, metadata.Height = int(tags[tagPixelXDimension].uint(0)), int(tags[tagPixelYDimension].uint(0))
+	if metadata.Width == 0 || metadata.Height == 0 {
+		metadata.Width, metadata.Height = int(tags[tagImageWidth].uint(0)), int(tags[tagImageLength].uint(0))
+	}
+
+	if gpsOffset, ok := tags[tagGPSPointer]; ok {
+		gpsTags, err := parser.readIFD(int64(gpsOffset.uint(0)))
+		if err == nil {
+			metadata.GPS = location(gpsTags)
+		}
+	}
+
+	return metadata, nil
+}
+
+// Location from the GPS IFD, nil when it has no coordinates
+func location(tags map[uint16]tagValue) *Location {
+	latitude, ok := degrees(tags[tagGPSLatitude])
+	if !ok {
+		return nil
+	}
+	longitude, ok := degrees(tags[tagGPSLongitude])
+	if !ok {
+		return nil
+	}
+
+	if strings.HasPrefix(tags[tagGPSLatitudeRef].string(), "S") {
+		latitude = -latitude
+	}
+	if strings.HasPrefix(tags[tagGPSLongitudeRef].string(), "W") {
+		longitude = -longitude
+	}
+
+	return &Location{Latitude: latitude, Longitude: longitude}
+}
+
+// Decimal degrees from degrees, minutes and seconds rationals
+func degrees(value tagValue) (float64, bool) {
+	if value.fieldType != typeRational || value.count != 3 {
+		return 0, false
+	}
+	return value.rational(0) + value.rational(1)/60 + value.rational(2)/3600, true
+}
+
+// Capture time from DateTimeOriginal, falling back to DateTime
+func captureTime(tags map[uint16]tagValue) time.Time {
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateTime} {
+		value, ok := tags[tag]
+		if !ok {
+			continue
+		}
+
+		text := strings.TrimSpace(value.string())
+		location := time.Local
+		if offset, ok := tags[tagOffsetTimeOriginal]; ok && tag == tagDateTimeOriginal {
+			if zone, err := time.Parse("-07:00", strings.TrimSpace(offset.string())); err == nil {
+				location = zone.Location()
+			}
+		}
+
+		captured, err := time.ParseInLocation(dateTimeLayout, text, location)
+		if err == nil {
+			return captured
+		}
+	}
+	return time.Time{}
+}
+
+// Reads IFDs out of a TIFF structure
+type tiffParser struct {
+	reader io.ReaderAt
+	order  binary.ByteOrder
+}
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+// Size in bytes of one value of each field type
+var typeSizes = map[uint16]int{
+	typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8,
+	6: 1, typeUndefined: 1, 8: 2, typeSLong: 4, typeSRational: 8,
+}
+
+// Raw value of a tag
+type tagValue struct {
+	fieldType uint16
+	count     int
+	data      []byte
+	order     binary.ByteOrder
+}
+
+// Upper bound on entries read from a single IFD, protects against corrupt files
+const maxIFDEntries = 1024
+
+// Read every tag in the IFD at offset
+func (parser *tiffParser) readIFD(offset int64) (map[uint16]tagValue, error) {
+	countBytes := make([]byte, 2)
+	if _, err := parser.reader.ReadAt(countBytes, offset); err != nil {
+		return nil, err
+	}
+
+	count := int(parser.order.Uint16(countBytes))
+	if count > maxIFDEntries {
+		return nil, fmt.Errorf("ifd with %d entries", count)
+	}
+
+	entries := make([]byte, count*12)
+	if _, err := parser.reader.ReadAt(entries, offset+2); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[uint16]tagValue, count)
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : (i+1)*12]
+		tag := parser.order.Uint16(entry[0:2])
+		fieldType := parser.order.Uint16(entry[2:4])
+		valueCount := int(parser.order.Uint32(entry[4:8]))
+
+		size, ok := typeSizes[fieldType]
+		if !ok || valueCount < 0 || valueCount > maxSegmentLength {
+			continue
+		}
+
+		data := entry[8:12]
+		if length := size * valueCount; length > 4 {
+			data = make([]byte, length)
+			if _, err := parser.reader.ReadAt(data, int64(parser.order.Uint32(entry[8:12]))); err != nil {
+				continue
+			}
+		} else {
+			data = data[:length]
+		}
+
+		tags[tag] = tagValue{fieldType: fieldType, count: valueCount, data: data, order: parser.order}
+	}
+
+	return tags, nil
+}
+
+// Value as a string, for ASCII tags
+func (value tagValue) string() string {
+	return strings.TrimRight(string(value.data), "\x00")
+}
+
+// Value at index as a float, for RATIONAL tags
+func (value tagValue) rational(index int) float64 {
+	if index >= value.count || value.fieldType != typeRational {
+		return 0
+	}
+	numerator := value.order.Uint32(value.data[index*8:])
+	denominator := value.order.Uint32(value.data[index*8+4:])
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+// Value at index as an unsigned integer, for BYTE, SHORT and LONG tags
+func (value tagValue) uint(index int) uint32 {
+	if index >= value.count {
+		return 0
+	}
+	switch value.fieldType {
+	case typeByte, typeUndefined:
+		return uint32(value.data[index])
+	case typeShort:
+		return uint32(value.order.Uint16(value.data[index*2:]))
+	case typeLong, typeSLong:
+		return value.order.Uint32(value.data[index*4:])
+	}
+	return 0
+}
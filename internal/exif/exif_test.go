@@ -0,0 +1,205 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A tag written by buildTIFF
+type testTag struct {
+	tag       uint16
+	fieldType uint16
+	data      []byte
+}
+
+func asciiTag(tag uint16, value string) testTag {
+	return testTag{tag, typeASCII, append([]byte(value), 0)}
+}
+
+func shortTag(tag uint16, value uint16) testTag {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, value)
+	return testTag{tag, typeShort, data}
+}
+
+func rationalTag(tag uint16, values ...uint32) testTag {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(data[i*4:], value)
+	}
+	return testTag{tag, typeRational, data}
+}
+
+// Build a little endian TIFF structure with an IFD0 and optional Exif and GPS IFDs
+func buildTIFF(ifd0 []testTag, exifIFD []testTag, gpsIFD []testTag) []byte {
+	order := binary.LittleEndian
+	var data bytes.Buffer
+	data.WriteString("II*\x00")
+	binary.Write(&data, order, uint32(8))
+
+	ifdSize := func(tags []testTag) uint32 {
+		return uint32(2 + 12*len(tags) + 4)
+	}
+
+	// IFD0 is followed by the Exif and GPS IFDs, then the values that don't fit in an entry
+	subIFDs := []struct {
+		pointer uint16
+		tags    []testTag
+	}{{tagExifPointer, exifIFD}, {tagGPSPointer, gpsIFD}}
+
+	// Pointer entries are added to IFD0 first so its size is known
+	var pointers []int
+	for _, sub := range subIFDs {
+		if len(sub.tags) > 0 {
+			pointers = append(pointers, len(ifd0))
+			ifd0 = append(ifd0, testTag{sub.pointer, typeLong, make([]byte, 4)})
+		}
+	}
+
+	offset := 8 + ifdSize(ifd0)
+	for _, sub := range subIFDs {
+		if len(sub.tags) == 0 {
+			continue
+		}
+		order.PutUint32(ifd0[pointers[0]].data, offset)
+		pointers = pointers[1:]
+		offset += ifdSize(sub.tags)
+	}
+	valueOffset := offset
+
+	var values bytes.Buffer
+	writeIFD := func(tags []testTag) {
+		binary.Write(&data, order, uint16(len(tags)))
+		for _, tag := range tags {
+			binary.Write(&data, order, tag.tag)
+			binary.Write(&data, order, tag.fieldType)
+			binary.Write(&data, order, uint32(len(tag.data)/typeSizes[tag.fieldType]))
+			if len(tag.data) <= 4 {
+				data.Write(append(tag.data, make([]byte, 4-len(tag.data))...))
+				continue
+			}
+			binary.Write(&data, order, valueOffset+uint32(values.Len()))
+			values.Write(tag.data)
+		}
+		binary.Write(&data, order, uint32(0))
+	}
+
+	writeIFD(ifd0)
+	for _, sub := range subIFDs {
+		if len(sub.tags) > 0 {
+			writeIFD(sub.tags)
+		}
+	}
+	data.Write(values.Bytes())
+	return data.Bytes()
+}
+
+// Wrap a TIFF structure in the APP1 segment of a minimal JPEG
+func buildJPEG(tiff []byte) []byte {
+	var data bytes.Buffer
+	data.Write([]byte{0xFF, 0xD8})
+	// JFIF segment first, like most cameras
+	data.Write([]byte{0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00})
+	data.Write([]byte{0xFF, 0xE1})
+	binary.Write(&data, binary.BigEndian, uint16(len(tiff)+8))
+	data.WriteString("Exif\x00\x00")
+	data.Write(tiff)
+	data.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0x00, 0xFF, 0xD9})
+	return data.Bytes()
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCaptureTime(t *testing.T) {
+
+	tiff := buildTIFF(
+		[]testTag{asciiTag(tagDateTime, "2022:01:01 00:00:00")},
+		[]testTag{asciiTag(tagDateTimeOriginal, "2021:07:04 18:30:05"), asciiTag(tagOffsetTimeOriginal, "+02:00")},
+		nil,
+	)
+
+	for name, data := range map[string][]byte{"photo.jpg": buildJPEG(tiff), "photo.dng": tiff} {
+		metadata, err := ReadFile(writeFile(t, name, data))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v; want nil", name, err)
+		}
+
+		want := time.Date(2021, 7, 4, 16, 30, 5, 0, time.UTC)
+		if !metadata.CaptureTime.Equal(want) {
+			t.Errorf("ReadFile(%s).CaptureTime = %v; want %v", name, metadata.CaptureTime, want)
+		}
+	}
+}
+
+func TestCaptureTimeFallback(t *testing.T) {
+
+	tiff := buildTIFF([]testTag{asciiTag(tagDateTime, "2022:01:01 10:00:00")}, nil, nil)
+
+	metadata, err := ReadFile(writeFile(t, "photo.jpg", buildJPEG(tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2022, 1, 1, 10, 0, 0, 0, time.Local)
+	if !metadata.CaptureTime.Equal(want) {
+		t.Errorf("CaptureTime = %v; want %v", metadata.CaptureTime, want)
+	}
+}
+
+func TestCameraAndLocation(t *testing.T) {
+
+	tiff := buildTIFF(
+		[]testTag{asciiTag(tagMake, "Canon"), asciiTag(tagModel, "Canon EOS R6"), shortTag(tagOrientation, 6)},
+		[]testTag{shortTag(tagPixelXDimension, 6000), shortTag(tagPixelYDimension, 4000)},
+		[]testTag{
+			asciiTag(tagGPSLatitudeRef, "S"),
+			rationalTag(tagGPSLatitude, 33, 1, 52, 1, 30, 1),
+			asciiTag(tagGPSLongitudeRef, "E"),
+			rationalTag(tagGPSLongitude, 151, 1, 12, 1, 3600, 100),
+		},
+	)
+
+	metadata, err := ReadFile(writeFile(t, "photo.jpg", buildJPEG(tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Make != "Canon" || metadata.Model != "Canon EOS R6" {
+		t.Errorf("Make, Model = %s, %s; want Canon, Canon EOS R6", metadata.Make, metadata.Model)
+	}
+	if metadata.Width != 6000 || metadata.Height != 4000 {
+		t.Errorf("Width, Height = %d, %d; want 6000, 4000", metadata.Width, metadata.Height)
+	}
+	if metadata.Orientation != 6 {
+		t.Errorf("Orientation = %d; want 6", metadata.Orientation)
+	}
+
+	if metadata.GPS == nil {
+		t.Fatalf("GPS = nil; want a location")
+	}
+	if math.Abs(metadata.GPS.Latitude+33.875) > 1e-9 || math.Abs(metadata.GPS.Longitude-151.21) > 1e-9 {
+		t.Errorf("GPS = %v; want -33.875, 151.21", *metadata.GPS)
+	}
+}
+
+func TestNoExif(t *testing.T) {
+
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9}
+	for name, data := range map[string][]byte{"photo.jpg": jpeg, "notes.txt": []byte("not a photo")} {
+		if _, err := ReadFile(writeFile(t, name, data)); !errors.Is(err, ErrNoExif) {
+			t.Errorf("ReadFile(%s) error = %v; want %v", name, err, ErrNoExif)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package fileops
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Move a file to a new location, creating any missing parent directories.
+// The file is hard linked to the destination and the source removed, so the destination
+// is created in one step and an existing file is never overwritten, even one created
+// while the move runs. When the filesystem can not link the file (another filesystem,
+// FAT drives) it is copied instead (keeping mode and mtime) and the source removed.
+func MoveFile(source, destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0750); err != nil {
+		return err
+	}
+
+	err := os.Link(source, destination)
+	if errors.Is(err, os.ErrExist) {
+		return &os.LinkError{Op: "move", Old: source, New: destination, Err: os.ErrExist}
+	}
+	if err != nil {
+		// Fall back to a copy, which also refuses to overwrite the destination
+		// and cleans up after itself
+		if err := CopyFile(source, destination); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(source); err != nil {
+		os.Remove(destination)
+		return err
+	}
+	return nil
+}
+
+// Copy a file to a new location, preserving its permissions and modification time.
+// The destination must not already exist and is removed again if the copy fails.
+func CopyFile(source, destination string) error {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, sourceInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// Never leave a partial copy behind
+	if _, err := io.Copy(destinationFile, sourceFile); err != nil {
+		destinationFile.Close()
+		os.Remove(destination)
+		return err
+	}
+
+	// Flush to disk before we consider the copy done
+	if err := destinationFile.Sync(); err != nil {
+		destinationFile.Close()
+		os.Remove(destination)
+		return err
+	}
+
+	if err := destinationFile.Close(); err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	if err := RestoreAttributes(destination, sourceInfo.Mode(), sourceInfo.ModTime()); err != nil {
+		os.Remove(destination)
+		return err
+	}
+	return nil
+}
+
+// Apply a permission set and modification time to a file
+func RestoreAttributes(path string, mode os.FileMode, modTime time.Time) error {
+	if err := os.Chmod(path, mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, modTime, modTime)
+}
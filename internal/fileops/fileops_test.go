@@ -0,0 +1,59 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFile(t *testing.T) {
+
+	directory := t.TempDir()
+	source := filepath.Join(directory, "photo.jpg")
+	if err := os.WriteFile(source, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(directory, "moved", "photo.jpg")
+	if err := MoveFile(source, destination); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(source); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Lstat(source) error = %v; want %v", err, os.ErrNotExist)
+	}
+	if contents, _ := os.ReadFile(destination); string(contents) != "photo" {
+		t.Errorf("destination contents = %q; want %q", contents, "photo")
+	}
+}
+
+func TestMoveFileExisting(t *testing.T) {
+
+	directory := t.TempDir()
+	source := filepath.Join(directory, "photo.jpg")
+	destination := filepath.Join(directory, "taken.jpg")
+	for path, contents := range map[string]string{source: "photo", destination: "another photo"} {
+		if err := os.WriteFile(path, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MoveFile(source, destination); !errors.Is(err, os.ErrExist) {
+		t.Errorf("MoveFile() error = %v; want %v", err, os.ErrExist)
+	}
+
+	// Neither file is touched
+	for path, want := range map[string]string{source: "photo", destination: "another photo"} {
+		if contents, _ := os.ReadFile(path); string(contents) != want {
+			t.Errorf("%s contents = %q; want %q", filepath.Base(path), contents, want)
+		}
+	}
+
+	// Nor is a file in the way of the copy fallback
+	if err := CopyFile(source, destination); !errors.Is(err, os.ErrExist) {
+		t.Errorf("CopyFile() error = %v; want %v", err, os.ErrExist)
+	}
+	if contents, _ := os.ReadFile(destination); string(contents) != "another photo" {
+		t.Errorf("destination contents after CopyFile() = %q; want %q", contents, "another photo")
+	}
+}
@@ -0,0 +1,114 @@
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Ways a duplicate can be replaced by a reference to the copy that is kept
+const (
+	// A second name for the kept copy, only on the same filesystem
+	Hardlink = "hardlink"
+	// A symbolic link to the absolute path of the kept copy
+	Symlink = "symlink"
+	// A copy-on-write clone sharing the kept copy's blocks (btrfs, XFS)
+	Reflink = "reflink"
+)
+
+// The filesystem can not link the two files, the duplicate was left untouched
+var ErrLinkUnsupported = errors.New("link not supported")
+
+// The duplicate already is the same file as the kept copy, there is nothing to reclaim
+var ErrAlreadyLinked = errors.New("already the same file")
+
+// Every link mode
+func LinkModes() []string {
+	return []string{Hardlink, Symlink, Reflink}
+}
+
+// Replace the duplicate at path with a link of the given mode to target.
+// The link is made under a temporary name next to path and renamed over it,
+// so on any failure the duplicate is left exactly as it was.
+// Reflinks keep the duplicate's permissions and modification time.
+func ReplaceWithLink(path, target, mode string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, targetInfo) {
+		return ErrAlreadyLinked
+	}
+
+	// Reserve a name in the same directory so the rename can not cross filesystems
+	temporaryFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".link-*")
+	if err != nil {
+		return err
+	}
+	temporary := temporaryFile.Name()
+
+	switch mode {
+	case Hardlink:
+		temporaryFile.Close()
+		os.Remove(temporary)
+		err = os.Link(target, temporary)
+		if errors.Is(err, syscall.EXDEV) {
+			err = fmt.Errorf("%w: %s and %s are on different filesystems", ErrLinkUnsupported, path, target)
+		}
+
+	case Symlink:
+		temporaryFile.Close()
+		os.Remove(temporary)
+		absoluteTarget, absErr := filepath.Abs(target)
+		if absErr != nil {
+			return absErr
+		}
+		err = os.Symlink(absoluteTarget, temporary)
+
+	case Reflink:
+		err = reflinkInto(target, temporaryFile)
+		if closeErr := temporaryFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = RestoreAttributes(temporary, info.Mode(), info.ModTime())
+		}
+
+	default:
+		temporaryFile.Close()
+		os.Remove(temporary)
+		return fmt.Errorf("unknown link mode %s", mode)
+	}
+
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	if err := os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+// Clone the contents of source into an empty open file
+func reflinkInto(source string, destination *os.File) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	return reflink(sourceFile, destination)
+}
@@ -0,0 +1,99 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a keeper and a duplicate of it, returning their paths
+func writePair(t *testing.T) (string, string) {
+	directory := t.TempDir()
+	keeper := filepath.Join(directory, "keeper.jpg")
+	duplicate := filepath.Join(directory, "duplicate.jpg")
+	for _, path := range []string{keeper, duplicate} {
+		if err := os.WriteFile(path, []byte("photo"), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keeper, duplicate
+}
+
+func TestReplaceWithHardlink(t *testing.T) {
+	keeper, duplicate := writePair(t)
+
+	if err := ReplaceWithLink(duplicate, keeper, Hardlink); err != nil {
+		t.Fatal(err)
+	}
+
+	keeperInfo, _ := os.Stat(keeper)
+	duplicateInfo, _ := os.Stat(duplicate)
+	if !os.SameFile(keeperInfo, duplicateInfo) {
+		t.Errorf("%s is not a hardlink to %s", duplicate, keeper)
+	}
+
+	if err := ReplaceWithLink(duplicate, keeper, Hardlink); !errors.Is(err, ErrAlreadyLinked) {
+		t.Errorf("ReplaceWithLink() twice error = %v; want %v", err, ErrAlreadyLinked)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(keeper))
+	if len(entries) != 2 {
+		t.Errorf("len(entries) = %d; want 2, temporary links must not be left behind", len(entries))
+	}
+}
+
+func TestReplaceWithSymlink(t *testing.T) {
+	keeper, duplicate := writePair(t)
+
+	if err := ReplaceWithLink(duplicate, keeper, Symlink); err != nil {
+		t.Fatal(err)
+	}
+
+	destination, err := os.Readlink(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if destination != keeper {
+		t.Errorf("os.Readlink(%s) = %s; want %s", duplicate, destination, keeper)
+	}
+
+	// Symlinks themselves are never replaced
+	if err := ReplaceWithLink(duplicate, keeper, Hardlink); err == nil {
+		t.Errorf("ReplaceWithLink() of a symlink error = nil; want an error")
+	}
+}
+
+func TestReplaceWithReflink(t *testing.T) {
+	keeper, duplicate := writePair(t)
+	modTime := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(duplicate, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ReplaceWithLink(duplicate, keeper, Reflink)
+	if errors.Is(err, ErrLinkUnsupported) {
+		// Most temporary directories are not on btrfs or XFS, the duplicate must be untouched
+		contents, readErr := os.ReadFile(duplicate)
+		if readErr != nil || string(contents) != "photo" {
+			t.Errorf("os.ReadFile(%s) = %q, %v; want the duplicate untouched", duplicate, contents, readErr)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(keeper))
+		if len(entries) != 2 {
+			t.Errorf("len(entries) = %d; want 2, temporary files must not be left behind", len(entries))
+		}
+		t.Skip("reflinks are not supported here")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("ModTime() = %v; want %v", info.ModTime(), modTime)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package fileops
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FICLONE ioctl from linux/fs.h
+const ficlone = 0x40049409
+
+func reflink(source, destination *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destination.Fd(), ficlone, source.Fd())
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EINVAL, syscall.EXDEV, syscall.ENOSYS:
+		// Filesystem without reflinks, or the files are on different filesystems
+		return fmt.Errorf("%w: unable to clone %s (%s)", ErrLinkUnsupported, source.Name(), errno)
+	}
+	return errno
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package fileops
+
+import (
+	"fmt"
+	"os"
+)
+
+// FICLONE is Linux only
+func reflink(source, destination *os.File) error {
+	return fmt.Errorf("%w: reflinks are only available on Linux", ErrLinkUnsupported)
+}
@@ -0,0 +1,308 @@
+package organize
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/deduplicator"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Name template used when none is given, keeps the original name
+const DefaultNameTemplate = "{name}"
+
+// Layout of the {date} token
+const dateLayout = "20060102-150405"
+
+// Folder template used when organizing by date
+const DefaultFolderTemplate = "{year}/{month}/{day}"
+
+// Hex characters of the digest kept by the {hash} token
+const hashLength = 16
+
+// Extensions for sources without one, by sniffed media type
+var mediaExtensions = map[string]string{
+	deduplicator.MediaJPEG: ".jpg",
+	deduplicator.MediaPNG:  ".png",
+	deduplicator.MediaGIF:  ".gif",
+	deduplicator.MediaTIFF: ".tif",
+	deduplicator.MediaWebP: ".webp",
+	deduplicator.MediaHEIC: ".heic",
+	deduplicator.MediaAVIF: ".avif",
+	deduplicator.MediaBMP:  ".bmp",
+}
+
+// What is known about a source file when naming its copy
+type Fields struct {
+	// Path of the source file
+	Source string
+	// Digest of the source ("sha256:..."), needed by {hash}
+	Hash string
+	// When the photo was taken, needed by {date}
+	CaptureTime time.Time
+	// Position of the file in the output, filled in by the Namer
+	Sequence int
+}
+
+// Expands a token into its value
+type tokenFunc func(fields Fields) (string, error)
+
+var tokens = map[string]tokenFunc{
+	// Original file name without its extension
+	"name": func(fields Fields) (string, error) {
+		base := filepath.Base(fields.Source)
+		return strings.TrimSuffix(base, filepath.Ext(base)), nil
+	},
+	// Start of the content digest in hex
+	"hash": func(fields Fields) (string, error) {
+		return shortHash(fields.Hash)
+	},
+	// Capture time as YYYYMMDD-HHMMSS
+	"date": captureTimeToken(dateLayout),
+	// Parts of the capture time, for folder templates
+	"year":  captureTimeToken("2006"),
+	"month": captureTimeToken("01"),
+	"day":   captureTimeToken("02"),
+	// Zero padded sequence number
+	"seq": func(fields Fields) (string, error) {
+		return fmt.Sprintf("%06d", fields.Sequence), nil
+	},
+	// Random UUID
+	"uuid": func(fields Fields) (string, error) {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	},
+}
+
+// Token formatting the capture time with a time layout
+func captureTimeToken(layout string) tokenFunc {
+	return func(fields Fields) (string, error) {
+		if fields.CaptureTime.IsZero() {
+			return "", errors.New("no capture time")
+		}
+		return fields.CaptureTime.Format(layout), nil
+	}
+}
+
+var tokenPattern = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// A parsed naming template such as "{date}_{name}".
+// Text outside of braces is copied as is.
+type Template struct {
+	text   string
+	tokens map[string]bool
+}
+
+// Parse a template, unknown tokens are an error
+func ParseTemplate(text string) (*Template, error) {
+	if text == "" {
+		return nil, errors.New("empty template")
+	}
+
+	template := &Template{text: text, tokens: make(map[string]bool)}
+	for _, match := range tokenPattern.FindAllStringSubmatch(text, -1) {
+		if _, ok := tokens[match[1]]; !ok {
+			return nil, fmt.Errorf("unknown token %s in template %s", match[0], text)
+		}
+		template.tokens[match[1]] = true
+	}
+
+	// Whatever is left must not look like a token
+	if strings.ContainsAny(tokenPattern.ReplaceAllString(text, ""), "{}") {
+		return nil, fmt.Errorf("unbalanced braces in template %s", text)
+	}
+
+	return template, nil
+}
+
+// Check if the template uses a token (without braces)
+func (template *Template) Uses(token string) bool {
+	return template.tokens[token]
+}
+
+// Fill in every token of the template
+func (template *Template) Expand(fields Fields) (string, error) {
+	var expandErr error
+	expanded := tokenPattern.ReplaceAllStringFunc(template.text, func(match string) string {
+		value, err := tokens[strings.Trim(match, "{}")](fields)
+		if err != nil && expandErr == nil {
+			expandErr = err
+		}
+		return value
+	})
+	return expanded, expandErr
+}
+
+// Check if expanding the template needs the capture time
+func (template *Template) usesCaptureTime() bool {
+	return template.Uses("date") || template.Uses("year") || template.Uses("month") || template.Uses("day")
+}
+
+// Picks destination paths in an output directory.
+// When two sources map to the same name the later one gets a "-1", "-2", ...
+// suffix, names already taken on disk are never reused.
+type Namer struct {
+	directory string
+	template  *Template
+	// Sub folders files are placed in, directly in directory when nil
+	folders  *Template
+	sequence int
+	// Paths handed out or already on disk, lower cased for case insensitive filesystems
+	reserved map[string]bool
+	// Directories whose existing files were added to reserved
+	scanned map[string]bool
+}
+
+// Create a namer placing files directly in directory
+func NewNamer(directory string, template *Template) (*Namer, error) {
+	if strings.ContainsAny(template.text, `/\`) {
+		return nil, fmt.Errorf("name template %s can not contain a path separator", template.text)
+	}
+
+	return &Namer{
+		directory: directory,
+		template:  template,
+		reserved:  make(map[string]bool),
+		scanned:   make(map[string]bool),
+	}, nil
+}
+
+// Place files in sub folders of the output directory built from a template
+// such as DefaultFolderTemplate. Folders may not point outside of the output directory.
+func (namer *Namer) SetFolders(folders *Template) {
+	namer.folders = folders
+}
+
+// Check if Next needs Fields.CaptureTime
+func (namer *Namer) NeedsCaptureTime() bool {
+	return namer.template.usesCaptureTime() || (namer.folders != nil && namer.folders.usesCaptureTime())
+}
+
+// Check if Next needs Fields.Hash
+func (namer *Namer) NeedsHash() bool {
+	return namer.template.Uses("hash") || (namer.folders != nil && namer.folders.Uses("hash"))
+}
+
+// Destination path for a source file. The name is reserved, the file itself
+// (and any folder it is placed in) is not created.
+func (namer *Namer) Next(fields Fields) (string, error) {
+	namer.sequence++
+	fields.Sequence = namer.sequence
+
+	directory, err := namer.folder(fields)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := namer.template.Expand(fields)
+	if err != nil {
+		return "", err
+	}
+	if base == "" || base == "." || base == ".." {
+		return "", fmt.Errorf("template %s gives an empty name for %s", namer.template.text, fields.Source)
+	}
+
+	extension, err := Extension(fields.Source)
+	if err != nil {
+		return "", err
+	}
+
+	if err := namer.scan(directory); err != nil {
+		return "", err
+	}
+
+	for suffix := 0; ; suffix++ {
+		name := base + extension
+		if suffix > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, suffix, extension)
+		}
+
+		destination := filepath.Join(directory, name)
+		if namer.reserved[strings.ToLower(destination)] {
+			continue
+		}
+
+		namer.reserved[strings.ToLower(destination)] = true
+		return destination, nil
+	}
+}
+
+// Directory a file is placed in
+func (namer *Namer) folder(fields Fields) (string, error) {
+	if namer.folders == nil {
+		return namer.directory, nil
+	}
+
+	folder, err := namer.folders.Expand(fields)
+	if err != nil {
+		return "", err
+	}
+
+	folder = filepath.Clean(filepath.FromSlash(folder))
+	if filepath.IsAbs(folder) || folder == ".." || strings.HasPrefix(folder, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("folder %s of %s is outside of %s", folder, fields.Source, namer.directory)
+	}
+
+	return filepath.Join(namer.directory, folder), nil
+}
+
+// Reserve the names of files already in a directory, once per directory
+func (namer *Namer) scan(directory string) error {
+	if namer.scanned[directory] {
+		return nil
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		namer.reserved[strings.ToLower(filepath.Join(directory, entry.Name()))] = true
+	}
+
+	namer.scanned[directory] = true
+	return nil
+}
+
+// Extension to give the copy of a source. The source's own extension is kept,
+// files without one get an extension from their sniffed media type.
+func Extension(source string) (string, error) {
+	if extension := filepath.Ext(filepath.Base(source)); extension != "" {
+		return extension, nil
+	}
+
+	mediaType, err := deduplicator.DetectMediaType(source)
+	if err != nil {
+		return "", err
+	}
+	return mediaExtensions[mediaType], nil
+}
+
+// First hashLength hex characters of a digest
+func shortHash(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("no hash for {hash}")
+	}
+
+	sum, err := base64.URLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	encoded := hex.EncodeToString(sum)
+	if len(encoded) > hashLength {
+		encoded = encoded[:hashLength]
+	}
+	return encoded, nil
+}
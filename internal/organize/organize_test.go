@@ -0,0 +1,222 @@
+package organize
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/deduplicator"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTemplate(t *testing.T) {
+
+	for _, text := range []string{"{name}", "{date}_{seq}", "photo-{hash}", "{uuid}"} {
+		if _, err := ParseTemplate(text); err != nil {
+			t.Errorf("ParseTemplate(%s) error = %v; want nil", text, err)
+		}
+	}
+
+	for _, text := range []string{"", "{camera}", "{name", "name}", "{Name}"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("ParseTemplate(%s) error = nil; want an error", text)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+
+	fields := Fields{
+		Source:      "photos/IMG_0001.HEIC",
+		Hash:        "sha256:3q2-7wAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		CaptureTime: time.Date(2021, 7, 4, 18, 30, 5, 0, time.UTC),
+		Sequence:    7,
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{name}", "IMG_0001"},
+		{"{date}_{name}", "20210704-183005_IMG_0001"},
+		{"{hash}", "deadbeef00000000"},
+		{"holiday-{seq}", "holiday-000007"},
+	}
+
+	for _, test := range tests {
+		template, err := ParseTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name, err := template.Expand(fields)
+		if err != nil {
+			t.Errorf("Expand(%s) error = %v; want nil", test.template, err)
+			continue
+		}
+		if name != test.want {
+			t.Errorf("Expand(%s) = %s; want %s", test.template, name, test.want)
+		}
+	}
+
+	template, _ := ParseTemplate("{hash}")
+	if _, err := template.Expand(Fields{Source: "IMG_0001.jpg"}); err == nil {
+		t.Errorf("Expand({hash}) without a hash error = nil; want an error")
+	}
+}
+
+func TestNamerCollisions(t *testing.T) {
+
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "beach.jpg"), []byte("taken"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	template, _ := ParseTemplate("{name}")
+	namer, err := NewNamer(directory, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := []string{"a/beach.jpg", "b/beach.jpg", "c/BEACH.JPG", "d/beach.png"}
+	want := []string{"beach-1.jpg", "beach-2.jpg", "BEACH-3.JPG", "beach.png"}
+
+	for i, source := range sources {
+		destination, err := namer.Next(Fields{Source: source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(destination) != want[i] {
+			t.Errorf("Next(%s) = %s; want %s", source, filepath.Base(destination), want[i])
+		}
+	}
+
+	separator, _ := ParseTemplate("{name}/{seq}")
+	if _, err := NewNamer(directory, separator); err == nil {
+		t.Errorf("NewNamer({name}/{seq}) error = nil; want an error")
+	}
+}
+
+func TestNamerStable(t *testing.T) {
+
+	// Same name in every folder, different contents so every photo is unique
+	input := t.TempDir()
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(input, fmt.Sprintf("%02d", i), "beach.jpg")
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("photo %d", i)), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template, _ := ParseTemplate("{name}")
+	var previous map[string]string
+	for run := 0; run < 5; run++ {
+		namer, err := NewNamer(t.TempDir(), template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Hashed by several workers, named as photos are served
+		deduper := deduplicator.New(input, 8, deduplicator.WithFilter(&deduplicator.Filter{}))
+		photoChannel := make(chan deduplicator.DedupeFileMetadata)
+		var photoWaitGroup sync.WaitGroup
+		deduper.Serve(context.Background(), photoChannel, &photoWaitGroup)
+		names := make(map[string]string)
+		for photoMetadata := range photoChannel {
+			destination, err := namer.Next(Fields{Source: photoMetadata.Path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names[photoMetadata.Path] = filepath.Base(destination)
+		}
+		photoWaitGroup.Wait()
+		if len(names) != 20 {
+			t.Fatalf("served %d photos; want 20", len(names))
+		}
+
+		if name := names[filepath.Join(input, "19", "beach.jpg")]; name != "beach-19.jpg" {
+			t.Errorf("name of 19/beach.jpg = %s; want beach-19.jpg", name)
+		}
+		for source, name := range names {
+			if previous != nil && previous[source] != name {
+				t.Errorf("run %d named %s %s; want %s as in the previous run", run, source, name, previous[source])
+			}
+		}
+		previous = names
+	}
+}
+
+func TestExtension(t *testing.T) {
+
+	directory := t.TempDir()
+	png := filepath.Join(directory, "exported")
+	if err := os.WriteFile(png, []byte("\x89PNG\r\n\x1A\n\x00\x00\x00\x0DIHDR"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"IMG_0001.HEIC", ".HEIC"},
+		{"clip.mov", ".mov"},
+		{png, ".png"},
+	}
+
+	for _, test := range tests {
+		extension, err := Extension(test.source)
+		if err != nil {
+			t.Errorf("Extension(%s) error = %v; want nil", test.source, err)
+			continue
+		}
+		if extension != test.want {
+			t.Errorf("Extension(%s) = %s; want %s", test.source, extension, test.want)
+		}
+	}
+}
+
+func TestNamerFolders(t *testing.T) {
+
+	directory := t.TempDir()
+	template, _ := ParseTemplate(DefaultNameTemplate)
+	namer, err := NewNamer(directory, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := ParseTemplate(DefaultFolderTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namer.SetFolders(folders)
+
+	if !namer.NeedsCaptureTime() {
+		t.Errorf("NeedsCaptureTime() = false; want true")
+	}
+
+	captured := time.Date(2021, 7, 4, 18, 30, 5, 0, time.UTC)
+	for _, want := range []string{"2021/07/04/beach.jpg", "2021/07/04/beach-1.jpg"} {
+		destination, err := namer.Next(Fields{Source: "beach.jpg", CaptureTime: captured})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if destination != filepath.Join(directory, filepath.FromSlash(want)) {
+			t.Errorf("Next(beach.jpg) = %s; want %s", destination, want)
+		}
+	}
+
+	if _, err := namer.Next(Fields{Source: "beach.jpg"}); err == nil {
+		t.Errorf("Next(beach.jpg) without a capture time error = nil; want an error")
+	}
+
+	escape, _ := ParseTemplate("../{year}")
+	namer.SetFolders(escape)
+	if _, err := namer.Next(Fields{Source: "beach.jpg", CaptureTime: captured}); err == nil {
+		t.Errorf("Next(beach.jpg) into ../2021 error = nil; want an error")
+	}
+}
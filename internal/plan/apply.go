@@ -0,0 +1,128 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/fileops"
+	"photo-deduplicator/internal/quarantine"
+)
+
+// Outcome of applying a plan
+type ApplyResult struct {
+	Applied []Action
+	// Actions left alone because the filesystem changed since planning, with the reason
+	Skipped map[string]string
+	// Actions that were attempted but failed, keyed by source
+	Failed map[string]error
+	// Manifests of the quarantine runs used by the plan
+	Manifests []string
+}
+
+// Carry out every action of a plan in order.
+// An action whose source or kept copy changed (size or modification time) or is gone
+// since the plan was made is skipped rather than applied on stale information.
+// Duplicates are purged through a new quarantine run so they can be restored.
+// Duplicates that can not be linked are left untouched and reported as failed.
+func Apply(plan *Plan) (ApplyResult, error) {
+	result := ApplyResult{
+		Skipped: make(map[string]string),
+		Failed:  make(map[string]error),
+	}
+
+	// Created when the first purge needs them, keyed by whether they delete
+	quarantines := make(map[bool]*quarantine.Quarantine)
+	defer func() {
+		for _, purger := range quarantines {
+			purger.Close()
+		}
+	}()
+
+	for _, action := range plan.Actions {
+		if reason := stale(action); reason != "" {
+			result.Skipped[action.Source] = reason
+			continue
+		}
+
+		var err error
+		switch action.Type {
+		case ActionCopy:
+			err = applyCopy(action)
+
+		case ActionQuarantine, ActionDelete:
+			deleteFiles := action.Type == ActionDelete
+			purger, ok := quarantines[deleteFiles]
+			if !ok {
+				if plan.Quarantine == "" {
+					return result, errors.New("plan has no quarantine directory")
+				}
+				purger, err = quarantine.New(plan.Quarantine, deleteFiles)
+				if err != nil {
+					return result, err
+				}
+				quarantines[deleteFiles] = purger
+				result.Manifests = append(result.Manifests, purger.ManifestPath())
+			}
+			_, err = purger.Purge(action.Source, action.DuplicateOf)
+
+		case ActionHardlink, ActionSymlink, ActionReflink:
+			err = fileops.ReplaceWithLink(action.Source, action.DuplicateOf, action.Type)
+			if errors.Is(err, fileops.ErrAlreadyLinked) {
+				result.Skipped[action.Source] = fmt.Sprintf("%s already is %s", action.Source, action.DuplicateOf)
+				continue
+			}
+
+		default:
+			err = fmt.Errorf("unknown action %s", action.Type)
+		}
+
+		if err != nil {
+			result.Failed[action.Source] = err
+			continue
+		}
+		result.Applied = append(result.Applied, action)
+	}
+
+	for deleteFiles, purger := range quarantines {
+		delete(quarantines, deleteFiles)
+		if err := purger.Close(); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Reason an action should not be applied anymore, empty when it still can be
+func stale(action Action) string {
+	info, err := os.Lstat(action.Source)
+	if err != nil {
+		return fmt.Sprintf("%s can not be read (%s)", action.Source, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Sprintf("%s is not a regular file anymore", action.Source)
+	}
+	if info.Size() != action.Size || !info.ModTime().Equal(action.ModTime) {
+		return fmt.Sprintf("%s changed since the plan was made", action.Source)
+	}
+
+	// Removing a duplicate of a kept copy that was edited or replaced would lose data
+	if action.DuplicateOf != "" {
+		keptInfo, err := os.Stat(action.DuplicateOf)
+		if err != nil {
+			return fmt.Sprintf("kept copy %s can not be read (%s)", action.DuplicateOf, err)
+		}
+		if keptInfo.Size() != action.KeptSize || !keptInfo.ModTime().Equal(action.KeptModTime) {
+			return fmt.Sprintf("kept copy %s changed since the plan was made", action.DuplicateOf)
+		}
+	}
+	return ""
+}
+
+func applyCopy(action Action) error {
+	if err := os.MkdirAll(filepath.Dir(action.Destination), 0750); err != nil {
+		return err
+	}
+	return fileops.CopyFile(action.Source, action.Destination)
+}
@@ -0,0 +1,174 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/fileops"
+	"time"
+)
+
+// Version of the plan file format
+const PlanVersion = 1
+
+// Kinds of action
+const (
+	// Copy a unique file to the output directory
+	ActionCopy = "copy"
+	// Move a duplicate into the quarantine
+	ActionQuarantine = "quarantine"
+	// Permanently delete a duplicate
+	ActionDelete = "delete"
+	// Replace a duplicate with a link to the copy that is kept, see fileops.ReplaceWithLink
+	ActionHardlink = fileops.Hardlink
+	ActionSymlink  = fileops.Symlink
+	ActionReflink  = fileops.Reflink
+)
+
+// One filesystem change
+type Action struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	// Where a copy is written, empty for anything else
+	Destination string `json:"destination,omitempty"`
+	// Copy that is kept when a duplicate is removed
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	// Source as it was when planned, the action is skipped if it changed since
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Kept copy as it was when planned, a duplicate is left alone if its kept copy changed since
+	KeptSize    int64     `json:"keptSize,omitempty"`
+	KeptModTime time.Time `json:"keptModTime,omitempty"`
+}
+
+// Every action a run would take, in order
+type Plan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Base directory of the quarantine used by quarantine and delete actions
+	Quarantine string   `json:"quarantine,omitempty"`
+	Actions    []Action `json:"actions"`
+}
+
+// Create an empty plan
+func New(quarantineDirectory string) *Plan {
+	if quarantineDirectory != "" {
+		if absolute, err := filepath.Abs(quarantineDirectory); err == nil {
+			quarantineDirectory = absolute
+		}
+	}
+
+	return &Plan{
+		Version:    PlanVersion,
+		Created:    time.Now(),
+		Quarantine: quarantineDirectory,
+		Actions:    []Action{},
+	}
+}
+
+// Add an action to the end of the plan. Paths are made absolute so the plan
+// can be applied from any directory.
+func (plan *Plan) Add(action Action) {
+	action.Source = absolutePath(action.Source)
+	action.Destination = absolutePath(action.Destination)
+	action.DuplicateOf = absolutePath(action.DuplicateOf)
+	plan.Actions = append(plan.Actions, action)
+}
+
+// Bytes freed from the scanned directories once the plan is applied
+func (plan *Plan) ReclaimedBytes() int64 {
+	var reclaimed int64
+	for _, action := range plan.Actions {
+		if action.Type != ActionCopy {
+			reclaimed += action.Size
+		}
+	}
+	return reclaimed
+}
+
+// Count of actions by type
+func (plan *Plan) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, action := range plan.Actions {
+		counts[action.Type]++
+	}
+	return counts
+}
+
+// Print every action in a human readable form
+func (plan *Plan) Print(output io.Writer) {
+	for _, action := range plan.Actions {
+		switch action.Type {
+		case ActionCopy:
+			fmt.Fprintf(output, "Would copy %s to %s\n", action.Source, action.Destination)
+		case ActionQuarantine:
+			fmt.Fprintf(output, "Would quarantine %s (duplicate of %s)\n", action.Source, action.DuplicateOf)
+		case ActionDelete:
+			fmt.Fprintf(output, "Would delete %s (duplicate of %s)\n", action.Source, action.DuplicateOf)
+		case ActionHardlink, ActionSymlink, ActionReflink:
+			fmt.Fprintf(output, "Would replace %s with a %s to %s\n", action.Source, action.Type, action.DuplicateOf)
+		default:
+			fmt.Fprintf(output, "Would %s %s\n", action.Type, action.Source)
+		}
+	}
+}
+
+// Write the plan to a file. The file is replaced in one rename so an interrupted save
+// never leaves a truncated plan behind to be applied.
+func (plan *Plan) Save(path string) error {
+	encoded, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Read a plan written by Save
+func Load(path string) (*Plan, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	if err := json.Unmarshal(contents, plan); err != nil {
+		return nil, fmt.Errorf("unable to parse plan %s: %w", path, err)
+	}
+
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d in %s", plan.Version, path)
+	}
+
+	return plan, nil
+}
+
+func absolutePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return absolute
+}
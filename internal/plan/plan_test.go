@@ -0,0 +1,204 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a file and return the action planning it
+func plannedFile(t *testing.T, path, contents string, action Action) Action {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0640); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action.Source = path
+	action.Size = info.Size()
+	action.ModTime = info.ModTime()
+	if action.DuplicateOf != "" {
+		keptInfo, err := os.Stat(action.DuplicateOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		action.KeptSize = keptInfo.Size()
+		action.KeptModTime = keptInfo.ModTime()
+	}
+	return action
+}
+
+func TestSaveLoad(t *testing.T) {
+
+	directory := t.TempDir()
+	plan := New(filepath.Join(directory, "quarantine"))
+	plan.Add(plannedFile(t, filepath.Join(directory, "a.jpg"), "photo", Action{Type: ActionCopy, Destination: filepath.Join(directory, "out", "a.jpg")}))
+	plan.Add(plannedFile(t, filepath.Join(directory, "b.jpg"), "photo", Action{Type: ActionQuarantine, DuplicateOf: filepath.Join(directory, "a.jpg")}))
+
+	if reclaimed := plan.ReclaimedBytes(); reclaimed != 5 {
+		t.Errorf("ReclaimedBytes() = %d; want 5", reclaimed)
+	}
+
+	// Saved over an older plan, leaving nothing else behind
+	path := filepath.Join(directory, "plan.json")
+	if err := os.WriteFile(path, []byte(strings.Repeat("older plan ", 1000)), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(directory, ".plan.json.*")); len(leftovers) != 0 {
+		t.Errorf("Save() left %v behind", leftovers)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Actions) != 2 || loaded.Quarantine != plan.Quarantine {
+		t.Fatalf("Load() = %d actions, quarantine %s; want 2, %s", len(loaded.Actions), loaded.Quarantine, plan.Quarantine)
+	}
+	if !loaded.Actions[1].ModTime.Equal(plan.Actions[1].ModTime) {
+		t.Errorf("ModTime = %v; want %v", loaded.Actions[1].ModTime, plan.Actions[1].ModTime)
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	directory := t.TempDir()
+	keeper := filepath.Join(directory, "a.jpg")
+	duplicate := filepath.Join(directory, "b.jpg")
+	changed := filepath.Join(directory, "c.jpg")
+	copied := filepath.Join(directory, "out", "2021", "a.jpg")
+
+	plan := New(filepath.Join(directory, "quarantine"))
+	plan.Add(plannedFile(t, keeper, "photo", Action{Type: ActionCopy, Destination: copied}))
+	plan.Add(plannedFile(t, duplicate, "photo", Action{Type: ActionQuarantine, DuplicateOf: keeper}))
+	plan.Add(plannedFile(t, changed, "photo", Action{Type: ActionDelete, DuplicateOf: keeper}))
+
+	// Edited after planning, must be left alone
+	if err := os.WriteFile(changed, []byte("edited photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Applied) != 2 || len(result.Failed) != 0 {
+		t.Errorf("Apply() applied %d, failed %v; want 2 applied, none failed", len(result.Applied), result.Failed)
+	}
+	if _, ok := result.Skipped[changed]; !ok {
+		t.Errorf("Apply() skipped %v; want %s", result.Skipped, changed)
+	}
+
+	if _, err := os.Stat(copied); err != nil {
+		t.Errorf("os.Stat(%s) error = %v; want nil", copied, err)
+	}
+	if _, err := os.Stat(duplicate); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) error = %v; want not exist", duplicate, err)
+	}
+	if _, err := os.Stat(changed); err != nil {
+		t.Errorf("os.Stat(%s) error = %v; want nil", changed, err)
+	}
+	if len(result.Manifests) != 1 {
+		t.Errorf("len(Manifests) = %d; want 1", len(result.Manifests))
+	}
+}
+
+func TestApplyLinks(t *testing.T) {
+
+	directory := t.TempDir()
+	keeper := filepath.Join(directory, "a.jpg")
+	hardlinked := filepath.Join(directory, "b.jpg")
+	symlinked := filepath.Join(directory, "c.jpg")
+
+	plan := New("")
+	plannedFile(t, keeper, "photo", Action{})
+	plan.Add(plannedFile(t, hardlinked, "photo", Action{Type: ActionHardlink, DuplicateOf: keeper}))
+	plan.Add(plannedFile(t, symlinked, "photo", Action{Type: ActionSymlink, DuplicateOf: keeper}))
+
+	if reclaimed := plan.ReclaimedBytes(); reclaimed != 10 {
+		t.Errorf("ReclaimedBytes() = %d; want 10", reclaimed)
+	}
+
+	result, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 2 {
+		t.Errorf("Apply() applied %d, skipped %v, failed %v; want 2 applied", len(result.Applied), result.Skipped, result.Failed)
+	}
+
+	keeperInfo, _ := os.Stat(keeper)
+	hardlinkedInfo, _ := os.Stat(hardlinked)
+	if !os.SameFile(keeperInfo, hardlinkedInfo) {
+		t.Errorf("%s is not a hardlink to %s", hardlinked, keeper)
+	}
+	if destination, err := os.Readlink(symlinked); err != nil || destination != keeper {
+		t.Errorf("os.Readlink(%s) = %s, %v; want %s", symlinked, destination, err, keeper)
+	}
+}
+
+func TestApplyKeeperChanged(t *testing.T) {
+
+	directory := t.TempDir()
+	keeper := filepath.Join(directory, "a.jpg")
+	deleted := filepath.Join(directory, "b.jpg")
+	linked := filepath.Join(directory, "c.jpg")
+
+	plan := New(filepath.Join(directory, "quarantine"))
+	plannedFile(t, keeper, "photo", Action{})
+	plan.Add(plannedFile(t, deleted, "photo", Action{Type: ActionDelete, DuplicateOf: keeper}))
+	plan.Add(plannedFile(t, linked, "photo", Action{Type: ActionHardlink, DuplicateOf: keeper}))
+
+	// Truncated and rewritten in place after planning, the duplicates are the only copies left
+	if err := os.WriteFile(keeper, []byte("edited"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 0 || len(result.Skipped) != 2 {
+		t.Errorf("Apply() applied %d, skipped %v; want none applied, 2 skipped", len(result.Applied), result.Skipped)
+	}
+	for _, path := range []string{deleted, linked} {
+		if contents, err := os.ReadFile(path); err != nil || string(contents) != "photo" {
+			t.Errorf("os.ReadFile(%s) = %q, %v; want photo", path, contents, err)
+		}
+	}
+}
+
+func TestApplySourceReplaced(t *testing.T) {
+
+	directory := t.TempDir()
+	keeper := filepath.Join(directory, "a.jpg")
+	duplicate := filepath.Join(directory, "b.jpg")
+
+	plan := New(filepath.Join(directory, "quarantine"))
+	plannedFile(t, keeper, "photo", Action{})
+	plan.Add(plannedFile(t, duplicate, "photo", Action{Type: ActionDelete, DuplicateOf: keeper}))
+
+	// No longer a file of its own
+	if err := os.Remove(duplicate); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(keeper, duplicate); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Skipped[duplicate]; !ok {
+		t.Errorf("Apply() skipped %v; want %s", result.Skipped, duplicate)
+	}
+}
@@ -0,0 +1,249 @@
+package quarantine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/fileops"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Version of the manifest format written by this package
+	ManifestVersion = 1
+	// Name of the manifest inside a run directory
+	ManifestName = "manifest.jsonl"
+
+	// Duplicate was moved into the quarantine directory
+	ActionQuarantined = "quarantined"
+	// Duplicate was removed from disk, it can not be restored
+	ActionDeleted = "deleted"
+)
+
+// First line of every manifest
+type ManifestHeader struct {
+	Version int       `json:"version"`
+	RunID   string    `json:"runId"`
+	Created time.Time `json:"created"`
+}
+
+// A single purged file. One entry is written per line after the header.
+type ManifestEntry struct {
+	Action         string      `json:"action"`
+	OriginalPath   string      `json:"originalPath"`
+	QuarantinePath string      `json:"quarantinePath,omitempty"`
+	DuplicateOf    string      `json:"duplicateOf"`
+	Size           int64       `json:"size"`
+	Mode           os.FileMode `json:"mode"`
+	ModTime        time.Time   `json:"modTime"`
+	Restored       bool        `json:"restored,omitempty"`
+}
+
+// Quarantine moves (or deletes) duplicates for a single run and records every
+// action in an append only manifest so the run can be reversed later.
+type Quarantine struct {
+	runDirectory string
+	deleteFiles  bool
+	manifest     *os.File
+	lock         sync.Mutex
+}
+
+// Create a new quarantine run inside of baseDirectory.
+// When deleteFiles is set duplicates are removed instead of moved, they are still
+// recorded in the manifest for auditing.
+func New(baseDirectory string, deleteFiles bool) (*Quarantine, error) {
+	now := time.Now()
+
+	baseDirectory, err := filepath.Abs(baseDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(baseDirectory, 0750); err != nil {
+		return nil, err
+	}
+
+	// Runs started within the same second get a "-1", "-2", ... suffix
+	runID := now.Format("20060102-150405")
+	runDirectory := filepath.Join(baseDirectory, runID)
+	for suffix := 1; ; suffix++ {
+		err := os.Mkdir(runDirectory, 0750)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		runID = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), suffix)
+		runDirectory = filepath.Join(baseDirectory, runID)
+	}
+
+	manifest, err := os.OpenFile(filepath.Join(runDirectory, ManifestName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantine := &Quarantine{
+		runDirectory: runDirectory,
+		deleteFiles:  deleteFiles,
+		manifest:     manifest,
+	}
+
+	header := ManifestHeader{
+		Version: ManifestVersion,
+		RunID:   runID,
+		Created: now,
+	}
+
+	if err := quarantine.writeLine(header); err != nil {
+		manifest.Close()
+		return nil, err
+	}
+
+	return quarantine, nil
+}
+
+// Directory holding the manifest and quarantined files for this run
+func (quarantine *Quarantine) RunDirectory() string {
+	return quarantine.runDirectory
+}
+
+// Path of the manifest for this run
+func (quarantine *Quarantine) ManifestPath() string {
+	return filepath.Join(quarantine.runDirectory, ManifestName)
+}
+
+// Purge a duplicate file. The file is moved into the run directory (mirroring its
+// absolute path) unless the quarantine was created to delete files.
+func (quarantine *Quarantine) Purge(path, duplicateOf string) (ManifestEntry, error) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	absoluteDuplicateOf, err := filepath.Abs(duplicateOf)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	// The duplicate may be the only copy left when the kept copy is not on this machine
+	if _, err := os.Stat(absoluteDuplicateOf); err != nil {
+		return ManifestEntry{}, fmt.Errorf("kept copy %s can not be read: %w", duplicateOf, err)
+	}
+
+	info, err := os.Stat(absolutePath)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return ManifestEntry{}, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	entry := ManifestEntry{
+		OriginalPath: absolutePath,
+		DuplicateOf:  absoluteDuplicateOf,
+		Size:         info.Size(),
+		Mode:         info.Mode(),
+		ModTime:      info.ModTime(),
+	}
+
+	if quarantine.deleteFiles {
+		entry.Action = ActionDeleted
+		if err := os.Remove(absolutePath); err != nil {
+			return ManifestEntry{}, err
+		}
+	} else {
+		entry.Action = ActionQuarantined
+		entry.QuarantinePath = quarantine.quarantinePath(absolutePath)
+		if err := fileops.MoveFile(absolutePath, entry.QuarantinePath); err != nil {
+			return ManifestEntry{}, err
+		}
+	}
+
+	// The file is already gone from its original location, the entry has to be recorded
+	if err := quarantine.writeLine(entry); err != nil {
+		return entry, fmt.Errorf("%s purged but not recorded in manifest: %w", path, err)
+	}
+
+	return entry, nil
+}
+
+// Close the manifest
+func (quarantine *Quarantine) Close() error {
+	quarantine.lock.Lock()
+	defer quarantine.lock.Unlock()
+	return quarantine.manifest.Close()
+}
+
+// Location inside of the run directory for a file
+func (quarantine *Quarantine) quarantinePath(absolutePath string) string {
+	relativePath := strings.TrimPrefix(absolutePath, filepath.VolumeName(absolutePath))
+	return filepath.Join(quarantine.runDirectory, "files", relativePath)
+}
+
+// Append a json line to the manifest and flush it to disk
+func (quarantine *Quarantine) writeLine(value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	quarantine.lock.Lock()
+	defer quarantine.lock.Unlock()
+
+	if _, err := quarantine.manifest.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return quarantine.manifest.Sync()
+}
+
+// Read a manifest written by a quarantine run
+func ReadManifest(path string) (ManifestHeader, []ManifestEntry, error) {
+	var (
+		header  ManifestHeader
+		entries []ManifestEntry
+	)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return header, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, errors.New("manifest is empty")
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("invalid manifest header: %w", err)
+	}
+
+	if header.Version != ManifestVersion {
+		return header, nil, fmt.Errorf("unsupported manifest version %d", header.Version)
+	}
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return header, nil, fmt.Errorf("invalid manifest entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return header, entries, scanner.Err()
+}
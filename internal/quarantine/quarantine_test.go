@@ -0,0 +1,224 @@
+package quarantine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write the copy purged files are duplicates of
+func writeOriginal(t *testing.T, directory string) string {
+	t.Helper()
+	original := filepath.Join(directory, "original.jpg")
+	if err := os.WriteFile(original, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return original
+}
+
+func TestPurgeQuarantine(t *testing.T) {
+
+	directory := t.TempDir()
+	duplicate := filepath.Join(directory, "photos", "duplicate.jpg")
+	if err := os.MkdirAll(filepath.Dir(duplicate), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(duplicate, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine, err := New(filepath.Join(directory, "quarantine"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := quarantine.Purge(duplicate, writeOriginal(t, directory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quarantine.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(duplicate); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) error = %v; want not exist", duplicate, err)
+	}
+
+	if _, err := os.Stat(entry.QuarantinePath); err != nil {
+		t.Errorf("os.Stat(%s) error = %v; want nil", entry.QuarantinePath, err)
+	}
+
+	header, entries, err := ReadManifest(quarantine.ManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Version != ManifestVersion {
+		t.Errorf("header.Version = %d; want %d", header.Version, ManifestVersion)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d; want 1", len(entries))
+	}
+
+	if entries[0].Action != ActionQuarantined {
+		t.Errorf("entries[0].Action = %s; want %s", entries[0].Action, ActionQuarantined)
+	}
+
+	if entries[0].Size != 5 {
+		t.Errorf("entries[0].Size = %d; want 5", entries[0].Size)
+	}
+}
+
+func TestPurgeDelete(t *testing.T) {
+
+	directory := t.TempDir()
+	duplicate := filepath.Join(directory, "duplicate.jpg")
+	if err := os.WriteFile(duplicate, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine, err := New(filepath.Join(directory, "quarantine"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quarantine.Close()
+
+	entry, err := quarantine.Purge(duplicate, writeOriginal(t, directory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Action != ActionDeleted {
+		t.Errorf("entry.Action = %s; want %s", entry.Action, ActionDeleted)
+	}
+
+	if entry.QuarantinePath != "" {
+		t.Errorf("entry.QuarantinePath = %s; want empty", entry.QuarantinePath)
+	}
+
+	if _, err := os.Stat(duplicate); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%s) error = %v; want not exist", duplicate, err)
+	}
+}
+
+func TestPurgeMissingOriginal(t *testing.T) {
+
+	directory := t.TempDir()
+	duplicate := filepath.Join(directory, "duplicate.jpg")
+	if err := os.WriteFile(duplicate, []byte("photo"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine, err := New(filepath.Join(directory, "quarantine"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quarantine.Close()
+
+	// Known from an index of another machine
+	if _, err := quarantine.Purge(duplicate, "/elsewhere/original.jpg"); err == nil {
+		t.Errorf("Purge() error = nil; want an error")
+	}
+	if _, err := os.Stat(duplicate); err != nil {
+		t.Errorf("os.Stat(%s) error = %v; want nil", duplicate, err)
+	}
+}
+
+func TestNewSameSecond(t *testing.T) {
+
+	directory := filepath.Join(t.TempDir(), "quarantine")
+	runDirectories := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		quarantine, err := New(directory, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer quarantine.Close()
+
+		if runDirectories[quarantine.RunDirectory()] {
+			t.Errorf("RunDirectory() = %s; want a new directory for every run", quarantine.RunDirectory())
+		}
+		runDirectories[quarantine.RunDirectory()] = true
+	}
+}
+
+func TestRestore(t *testing.T) {
+
+	directory := t.TempDir()
+	restorable := filepath.Join(directory, "restorable.jpg")
+	conflicting := filepath.Join(directory, "conflicting.jpg")
+	modTime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, path := range []string{restorable, conflicting} {
+		if err := os.WriteFile(path, []byte("photo"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quarantine, err := New(filepath.Join(directory, "quarantine"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{restorable, conflicting} {
+		if _, err := quarantine.Purge(path, writeOriginal(t, directory)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quarantine.Close()
+
+	// Something new shows up where a purged file used to be
+	if err := os.WriteFile(conflicting, []byte("new photo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Restore(quarantine.ManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Restored) != 1 || result.Restored[0].OriginalPath != restorable {
+		t.Errorf("result.Restored = %v; want %s", result.Restored, restorable)
+	}
+
+	if len(result.Conflicts) != 1 || result.Conflicts[0].OriginalPath != conflicting {
+		t.Errorf("result.Conflicts = %v; want %s", result.Conflicts, conflicting)
+	}
+
+	info, err := os.Stat(restorable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("info.ModTime() = %v; want %v", info.ModTime(), modTime)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("info.Mode().Perm() = %v; want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// Conflicting file must be untouched
+	contents, err := os.ReadFile(conflicting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "new photo" {
+		t.Errorf("conflicting contents = %s; want new photo", contents)
+	}
+
+	// Rerunning only reports the remaining conflict
+	result, err = Restore(quarantine.ManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Restored) != 0 {
+		t.Errorf("len(result.Restored) = %d; want 0", len(result.Restored))
+	}
+}
@@ -0,0 +1,116 @@
+package quarantine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/fileops"
+)
+
+// Outcome of restoring a quarantine run
+type RestoreResult struct {
+	// Files moved back to their original path
+	Restored []ManifestEntry
+	// Files skipped because something now exists at the original path
+	Conflicts []ManifestEntry
+	// Files that were permanently deleted and can not come back
+	Unrestorable []ManifestEntry
+	// Files that failed to restore, keyed by original path
+	Failed map[string]error
+}
+
+// Restore every quarantined file listed in a manifest to its original path with
+// its original permissions and modification time.
+// Existing files are never overwritten, they are reported as conflicts instead.
+// The manifest is rewritten to mark restored entries so a restore can be rerun safely.
+func Restore(manifestPath string) (RestoreResult, error) {
+	result := RestoreResult{
+		Failed: make(map[string]error),
+	}
+
+	header, entries, err := ReadManifest(manifestPath)
+	if err != nil {
+		return result, err
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+
+		if entry.Restored {
+			continue
+		}
+
+		if entry.Action != ActionQuarantined {
+			result.Unrestorable = append(result.Unrestorable, *entry)
+			continue
+		}
+
+		if _, err := os.Lstat(entry.OriginalPath); err == nil {
+			result.Conflicts = append(result.Conflicts, *entry)
+			continue
+		} else if !os.IsNotExist(err) {
+			result.Failed[entry.OriginalPath] = err
+			continue
+		}
+
+		// Still a conflict when a file showed up at the original path since
+		if err := fileops.MoveFile(entry.QuarantinePath, entry.OriginalPath); errors.Is(err, os.ErrExist) {
+			result.Conflicts = append(result.Conflicts, *entry)
+			continue
+		} else if err != nil {
+			result.Failed[entry.OriginalPath] = err
+			continue
+		}
+
+		if err := fileops.RestoreAttributes(entry.OriginalPath, entry.Mode, entry.ModTime); err != nil {
+			result.Failed[entry.OriginalPath] = fmt.Errorf("restored but attributes not applied: %w", err)
+		}
+
+		entry.Restored = true
+		result.Restored = append(result.Restored, *entry)
+	}
+
+	if len(result.Restored) > 0 {
+		if err := writeManifest(manifestPath, header, entries); err != nil {
+			return result, fmt.Errorf("unable to update manifest: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Replace a manifest on disk. Written to a temporary file first so a failure
+// never leaves a truncated manifest behind.
+func writeManifest(manifestPath string, header ManifestHeader, entries []ManifestEntry) error {
+	temporaryFile, err := os.CreateTemp(filepath.Dir(manifestPath), ManifestName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	encoder := json.NewEncoder(temporaryFile)
+	if err := encoder.Encode(header); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			temporaryFile.Close()
+			return err
+		}
+	}
+
+	if err := temporaryFile.Sync(); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+
+	if err := temporaryFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryFile.Name(), manifestPath)
+}
@@ -0,0 +1,310 @@
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// Decoders used for thumbnails
+	_ "image/gif"
+	_ "image/png"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Longest side of a thumbnail in pixels
+const thumbnailSize = 240
+
+// Duplicate group as shown in the HTML report
+type htmlGroup struct {
+	ID      int
+	Hash    string
+	Members []htmlMember
+	// Bytes freed by removing every copy but the keeper
+	Reclaimable string
+}
+
+type htmlMember struct {
+	Record
+	Thumbnail template.URL
+	SizeText  string
+	Date      string
+	// Keeper that was only matched against, such as a reference file
+	NotScanned bool
+}
+
+// Buffers every record and renders the duplicate groups as a single HTML page on Close.
+// Thumbnails are embedded so the page can be moved around on its own.
+type htmlWriter struct {
+	output  io.Writer
+	records []Record
+}
+
+func (writer *htmlWriter) Write(record Record) error {
+	writer.records = append(writer.records, record)
+	return nil
+}
+
+func (writer *htmlWriter) Close() error {
+	groups, reclaimable := htmlGroups(writer.records)
+
+	return htmlTemplate.Execute(writer.output, struct {
+		Files       int
+		Groups      []htmlGroup
+		Reclaimable string
+	}{len(writer.records), groups, formatSize(reclaimable)})
+}
+
+// Group records by group id, keeping only groups with duplicates.
+// Keepers come first in each group.
+func htmlGroups(records []Record) ([]htmlGroup, int64) {
+	byID := make(map[int][]Record)
+	for _, record := range records {
+		byID[record.GroupID] = append(byID[record.GroupID], record)
+	}
+
+	var groups []htmlGroup
+	var totalReclaimable int64
+	for id, members := range byID {
+		// Keepers in a reference library or left alone by an incremental run are never served
+		notScanned := ""
+		if keeper, ok := missingKeeper(members); ok {
+			members = append(members, keeper)
+			notScanned = keeper.Path
+		}
+		if len(members) < 2 {
+			continue
+		}
+
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].Keeper != members[j].Keeper {
+				return members[i].Keeper
+			}
+			return members[i].Path < members[j].Path
+		})
+
+		group := htmlGroup{ID: id}
+		var reclaimable int64
+		for _, member := range members {
+			if member.Hash != "" {
+				group.Hash = member.Hash
+			}
+			if !member.Keeper {
+				reclaimable += member.Size
+			}
+			htmlMember := newHTMLMember(member)
+			htmlMember.NotScanned = member.Path == notScanned
+			group.Members = append(group.Members, htmlMember)
+		}
+		group.Reclaimable = formatSize(reclaimable)
+		totalReclaimable += reclaimable
+
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+	return groups, totalReclaimable
+}
+
+// Record of the keeper of a group whose keeper was not served, built from what its duplicates know
+func missingKeeper(members []Record) (Record, bool) {
+	for _, member := range members {
+		if member.Keeper {
+			return Record{}, false
+		}
+	}
+	for _, member := range members {
+		if member.DuplicateOf == "" {
+			continue
+		}
+		keeper := Record{Path: member.DuplicateOf, Hash: member.Hash, Size: member.Size, GroupID: member.GroupID, Keeper: true, Action: ActionNone}
+		// Originals loaded from an index may not exist on this machine
+		if info, err := os.Stat(keeper.Path); err == nil {
+			keeper.Size = info.Size()
+			keeper.ModTime = info.ModTime()
+		}
+		return keeper, true
+	}
+	return Record{}, false
+}
+
+func newHTMLMember(record Record) htmlMember {
+	member := htmlMember{Record: record, SizeText: formatSize(record.Size)}
+
+	if record.CaptureTime != nil {
+		member.Date = record.CaptureTime.Format("2006-01-02 15:04:05")
+	} else if !record.ModTime.IsZero() {
+		member.Date = record.ModTime.Format("2006-01-02 15:04:05") + " (modified)"
+	}
+
+	thumbnail, err := thumbnail(thumbnailSource(record))
+	if err != nil {
+		log.Debug("No thumbnail for ", record.Path, " (", err, ")")
+	} else {
+		member.Thumbnail = template.URL(thumbnail)
+	}
+	return member
+}
+
+// File to make the thumbnail of a record from. Removed duplicates are gone by the time the report is
+// written, quarantined ones are read where they were moved to and deleted ones from their kept copy,
+// which has the same contents.
+func thumbnailSource(record Record) string {
+	for _, path := range []string{record.Path, record.Destination, record.DuplicateOf} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return record.Path
+}
+
+// Scale a photo down to thumbnailSize and return it as a JPEG data URI
+func thumbnail(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	photo, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, shrink(photo, thumbnailSize), &jpeg.Options{Quality: 75}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes()), nil
+}
+
+// Shrink an image so its longest side is at most size, averaging the source pixels of each
+// thumbnail pixel. Images that are already small enough are returned as is.
+func shrink(photo image.Image, size int) image.Image {
+	bounds := photo.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return photo
+	}
+
+	if width >= height {
+		width, height = size, max(1, height*size/width)
+	} else {
+		width, height = max(1, width*size/height), size
+	}
+
+	sums := make([][4]uint64, width*height)
+	counts := make([]uint64, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cellY := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cellX := (x - bounds.Min.X) * width / bounds.Dx()
+			r, g, b, a := photo.At(x, y).RGBA()
+			cell := cellY*width + cellX
+			sums[cell][0] += uint64(r)
+			sums[cell][1] += uint64(g)
+			sums[cell][2] += uint64(b)
+			sums[cell][3] += uint64(a)
+			counts[cell]++
+		}
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	for cell, sum := range sums {
+		if counts[cell] == 0 {
+			continue
+		}
+		offset := cell * 4
+		for channel := 0; channel < 4; channel++ {
+			thumbnail.Pix[offset+channel] = uint8(sum[channel] / counts[cell] >> 8)
+		}
+	}
+	return thumbnail
+}
+
+func max(first, second int) int {
+	if first > second {
+		return first
+	}
+	return second
+}
+
+// Size in bytes as a human readable string
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	divisor, exponent := int64(unit), 0
+	for remaining := size / unit; remaining >= unit; remaining /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(divisor), "KMGTPE"[exponent])
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"base": filepath.Base,
+	"ext":  func(path string) string { return strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), ".")) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duplicate photos</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #fafafa; color: #222; }
+section { background: #fff; border: 1px solid #ddd; border-radius: 6px; margin-bottom: 1.5em; padding: 1em; }
+h2 { font-size: 1em; margin: 0 0 1em 0; }
+.hash { color: #888; font-family: monospace; font-weight: normal; }
+.members { display: flex; flex-wrap: wrap; gap: 1em; }
+figure { margin: 0; width: 260px; padding: 8px; border: 3px solid #eee; border-radius: 6px; }
+figure.keeper { border-color: #2e7d32; background: #f1f8e9; }
+.thumbnail { width: 240px; height: 240px; display: flex; align-items: center; justify-content: center; background: #eee; }
+.thumbnail img { max-width: 240px; max-height: 240px; }
+.placeholder { color: #888; font-size: 2em; }
+figcaption { font-size: 0.85em; margin-top: 0.5em; word-break: break-all; }
+.badge { display: inline-block; padding: 1px 6px; border-radius: 3px; background: #2e7d32; color: #fff; font-size: 0.8em; }
+.badge.remove { background: #c62828; }
+.details { color: #666; }
+</style>
+</head>
+<body>
+<h1>Duplicate photos</h1>
+<p>{{.Files}} files scanned, {{len .Groups}} duplicate groups, {{.Reclaimable}} held by duplicates.</p>
+{{range .Groups}}
+<section>
+<h2>Group {{.ID}}: {{len .Members}} copies, {{.Reclaimable}} reclaimable <span class="hash">{{.Hash}}</span></h2>
+<div class="members">
+{{range .Members}}
+<figure{{if .Keeper}} class="keeper"{{end}}>
+<div class="thumbnail">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="{{base .Path}}">{{else}}<span class="placeholder">{{ext .Path}}</span>{{end}}</div>
+<figcaption>
+{{if .Keeper}}<span class="badge">keep</span>{{else}}<span class="badge remove">{{if eq .Action "none"}}duplicate{{else}}{{.Action}}{{end}}</span>{{end}}
+<div>{{.Path}}</div>
+<div class="details">{{.SizeText}}{{if .Date}} &middot; {{.Date}}{{end}}</div>
+{{if .Root}}<div class="details">in {{.Root}}</div>{{end}}
+{{if .NotScanned}}<div class="details">not part of this scan</div>{{end}}
+</figcaption>
+</figure>
+{{end}}
+</div>
+</section>
+{{else}}
+<p>No duplicates found.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+
+	directory := t.TempDir()
+	photo := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			photo.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, photo); err != nil {
+		t.Fatal(err)
+	}
+
+	keeper := filepath.Join(directory, "keeper.png")
+	duplicate := filepath.Join(directory, "duplicate.png")
+	for _, path := range []string{keeper, duplicate} {
+		if err := os.WriteFile(path, encoded.Bytes(), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var output bytes.Buffer
+	writer, err := New(FormatHTML, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		{Path: duplicate, Size: 2048, GroupID: 1, Action: "quarantined"},
+		{Path: keeper, Size: 2048, GroupID: 1, Keeper: true, Action: ActionNone},
+		{Path: filepath.Join(directory, "unique.mov"), Size: 10, GroupID: 2, Keeper: true, Action: ActionNone},
+	}
+	for _, record := range records {
+		writer.Write(record)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	page := output.String()
+	for _, want := range []string{"data:image/jpeg;base64,", `class="keeper"`, keeper, duplicate, "quarantined", "2.0 KB reclaimable"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report is missing %q", want)
+		}
+	}
+
+	// Unique files are not part of any group
+	if strings.Contains(page, "unique.mov") {
+		t.Errorf("report contains unique.mov; want only duplicate groups")
+	}
+
+	// The keeper is listed first
+	if strings.Index(page, keeper) > strings.Index(page, duplicate) {
+		t.Errorf("keeper listed after its duplicate")
+	}
+}
+
+func TestHTMLRemovedThumbnails(t *testing.T) {
+
+	directory := t.TempDir()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	keeper := filepath.Join(directory, "keeper.png")
+	quarantined := filepath.Join(directory, "quarantine", "quarantined.png")
+	for _, path := range []string{keeper, quarantined} {
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, encoded.Bytes(), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var output bytes.Buffer
+	writer, err := New(FormatHTML, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither duplicate is at its path anymore
+	writer.Write(Record{Path: keeper, GroupID: 1, Keeper: true, Action: ActionNone})
+	writer.Write(Record{Path: filepath.Join(directory, "moved.png"), GroupID: 1, DuplicateOf: keeper, Action: "quarantined", Destination: quarantined})
+	writer.Write(Record{Path: filepath.Join(directory, "deleted.png"), GroupID: 1, DuplicateOf: keeper, Action: "deleted"})
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	page := output.String()
+	if thumbnails := strings.Count(page, "data:image/jpeg;base64,"); thumbnails != 3 {
+		t.Errorf("report has %d thumbnails; want 3", thumbnails)
+	}
+	if strings.Contains(page, `class="placeholder"`) {
+		t.Errorf("report has placeholders; want a thumbnail for every member")
+	}
+}
+
+func TestHTMLKeeperNotServed(t *testing.T) {
+
+	directory := t.TempDir()
+	archived := filepath.Join(directory, "archive", "a.jpg")
+	imported := filepath.Join(directory, "import", "a.jpg")
+
+	var output bytes.Buffer
+	writer, err := New(FormatHTML, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the import is served when the keeper is in a reference library
+	writer.Write(Record{Path: imported, Hash: "sha256:a", Size: 2048, GroupID: 1, DuplicateOf: archived, Action: "quarantined"})
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	page := output.String()
+	if strings.Contains(page, "No duplicates found") {
+		t.Fatalf("report = no duplicates; want the group of %s", imported)
+	}
+	for _, want := range []string{archived, imported, "2 copies", "2.0 KB reclaimable", "not part of this scan"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report is missing %q", want)
+		}
+	}
+	if strings.Index(page, archived) > strings.Index(page, imported) {
+		t.Errorf("keeper listed after its duplicate")
+	}
+}
+
+func TestShrink(t *testing.T) {
+
+	thumbnail := shrink(image.NewRGBA(image.Rect(0, 0, 1000, 250)), thumbnailSize)
+	if bounds := thumbnail.Bounds(); bounds.Dx() != thumbnailSize || bounds.Dy() != 60 {
+		t.Errorf("shrink(1000x250) = %dx%d; want %dx60", bounds.Dx(), bounds.Dy(), thumbnailSize)
+	}
+}
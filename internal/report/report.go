@@ -0,0 +1,270 @@
+package report
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/deduplicator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats
+const (
+	// One JSON array holding every record
+	FormatJSON = "json"
+	// A header row followed by one row per record
+	FormatCSV = "csv"
+	// One JSON object per line, readable while the scan is still running
+	FormatNDJSON = "ndjson"
+	// A page showing thumbnails of every duplicate group, see htmlWriter
+	FormatHTML = "html"
+)
+
+// What was done with a file
+const (
+	ActionNone   = "none"
+	ActionCopied = "copied"
+	ActionFailed = "failed"
+	// Planned by a dry run, nothing was done
+	ActionPlanned = "planned"
+	// Replaced by a link to the copy that is kept
+	ActionHardlinked = "hardlinked"
+	ActionSymlinked  = "symlinked"
+	ActionReflinked  = "reflinked"
+	// Purged files use the quarantine actions (quarantined, deleted)
+)
+
+// One file of a scan
+type Record struct {
+	Path    string    `json:"path"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// EXIF capture time, nil when unknown
+	CaptureTime *time.Time `json:"capture_time,omitempty"`
+	GroupID     int        `json:"group_id"`
+	Keeper      bool       `json:"keeper"`
+	Status      string     `json:"status"`
+	DuplicateOf string     `json:"duplicate_of,omitempty"`
+	Action      string     `json:"action"`
+	// Where the file was copied or moved to
+	Destination string `json:"destination,omitempty"`
+	// Input root the file was found in
+	Root string `json:"root"`
+}
+
+// Columns of the CSV format
+var csvHeader = []string{"path", "hash", "size", "mod_time", "capture_time", "group_id", "keeper", "status", "duplicate_of", "action", "destination", "root"}
+
+// Build the record of a served file. Every file that is not a duplicate is a keeper.
+func NewRecord(photoMetadata deduplicator.DedupeFileMetadata, action string, destination string) Record {
+	record := Record{
+		Path:        photoMetadata.Path,
+		Hash:        photoMetadata.Hash,
+		Size:        photoMetadata.Size,
+		ModTime:     photoMetadata.ModTime,
+		GroupID:     photoMetadata.GroupID,
+		Keeper:      photoMetadata.DuplicatePath == "",
+		Status:      photoMetadata.Status.String(),
+		DuplicateOf: photoMetadata.DuplicatePath,
+		Action:      action,
+		Destination: destination,
+		Root:        photoMetadata.Root,
+	}
+
+	if photoMetadata.Exif != nil && !photoMetadata.Exif.CaptureTime.IsZero() {
+		captureTime := photoMetadata.Exif.CaptureTime
+		record.CaptureTime = &captureTime
+	}
+	return record
+}
+
+// Writes records in one of the report formats
+type Writer interface {
+	Write(record Record) error
+	// Finish the report, no records can be written afterwards
+	Close() error
+}
+
+// Guess the format of a report from its file extension, JSON when unknown
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".html", ".htm":
+		return FormatHTML
+	}
+	return FormatJSON
+}
+
+// Create a report file, overwriting any previous report
+func Create(path string, format string) (Writer, error) {
+	if _, err := newEncoder(format, io.Discard); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bufio.NewWriter(file)
+	encoder, _ := newEncoder(format, buffer)
+	return &fileWriter{file: file, buffer: buffer, encoder: encoder, stream: strings.ToLower(format) == FormatNDJSON}, nil
+}
+
+// Write a report to output, which is left open
+func New(format string, output io.Writer) (Writer, error) {
+	return newEncoder(format, output)
+}
+
+func newEncoder(format string, output io.Writer) (Writer, error) {
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return &jsonWriter{output: output}, nil
+	case FormatCSV:
+		return &csvWriter{output: csv.NewWriter(output)}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{output: json.NewEncoder(output)}, nil
+	case FormatHTML:
+		return &htmlWriter{output: output}, nil
+	}
+	return nil, fmt.Errorf("unknown report format %s", format)
+}
+
+// Report written to a file. Records are buffered until it is closed, unless streaming.
+type fileWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder Writer
+	// Flush every record so the report can be followed while it is written
+	stream bool
+}
+
+func (writer *fileWriter) Write(record Record) error {
+	if err := writer.encoder.Write(record); err != nil {
+		return err
+	}
+	if writer.stream {
+		return writer.buffer.Flush()
+	}
+	return nil
+}
+
+func (writer *fileWriter) Close() error {
+	err := writer.encoder.Close()
+	if flushErr := writer.buffer.Flush(); err == nil {
+		err = flushErr
+	}
+	if syncErr := writer.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Streams a JSON array, one record per line
+type jsonWriter struct {
+	output  io.Writer
+	records int
+}
+
+func (writer *jsonWriter) Write(record Record) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n  "
+	if writer.records == 0 {
+		separator = "[\n  "
+	}
+	writer.records++
+
+	_, err = io.WriteString(writer.output, separator+string(encoded))
+	return err
+}
+
+func (writer *jsonWriter) Close() error {
+	end := "\n]\n"
+	if writer.records == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(writer.output, end)
+	return err
+}
+
+type csvWriter struct {
+	output        *csv.Writer
+	headerWritten bool
+}
+
+func (writer *csvWriter) Write(record Record) error {
+	if !writer.headerWritten {
+		if err := writer.output.Write(csvHeader); err != nil {
+			return err
+		}
+		writer.headerWritten = true
+	}
+
+	captureTime := ""
+	if record.CaptureTime != nil {
+		captureTime = formatTime(*record.CaptureTime)
+	}
+
+	return writer.output.Write([]string{
+		record.Path,
+		record.Hash,
+		strconv.FormatInt(record.Size, 10),
+		formatTime(record.ModTime),
+		captureTime,
+		strconv.Itoa(record.GroupID),
+		strconv.FormatBool(record.Keeper),
+		record.Status,
+		record.DuplicateOf,
+		record.Action,
+		record.Destination,
+		record.Root,
+	})
+}
+
+func (writer *csvWriter) Close() error {
+	// An empty report still gets its header
+	if !writer.headerWritten {
+		if err := writer.output.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	writer.output.Flush()
+	return writer.output.Error()
+}
+
+// Times in CSV reports, empty when unknown
+func formatTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format(time.RFC3339)
+}
+
+type ndjsonWriter struct {
+	output *json.Encoder
+}
+
+func (writer *ndjsonWriter) Write(record Record) error {
+	return writer.output.Encode(record)
+}
+
+func (writer *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"photo-deduplicator/internal/deduplicator"
+	"strings"
+	"testing"
+)
+
+var testRecords = []Record{
+	NewRecord(deduplicator.DedupeFileMetadata{Path: "a.jpg", Hash: "sha256:a", Size: 5, GroupID: 1}, ActionCopied, "out/a.jpg"),
+	NewRecord(deduplicator.DedupeFileMetadata{Path: "b.jpg", Hash: "sha256:a", Size: 5, GroupID: 1, DuplicatePath: "a.jpg", Status: deduplicator.StatusDuplicate, Root: "sdcard"}, "quarantined", ""),
+}
+
+func writeReport(t *testing.T, format string) []byte {
+	t.Helper()
+	var output bytes.Buffer
+	writer, err := New(format, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range testRecords {
+		if err := writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+func TestJSON(t *testing.T) {
+
+	var records []Record
+	if err := json.Unmarshal(writeReport(t, FormatJSON), &records); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("len(records) = %d; want 2", len(records))
+	}
+	if records[0] != testRecords[0] || records[1] != testRecords[1] {
+		t.Errorf("records = %v; want %v", records, testRecords)
+	}
+	if !records[0].Keeper || records[1].Keeper {
+		t.Errorf("Keeper = %t, %t; want true, false", records[0].Keeper, records[1].Keeper)
+	}
+
+	// An empty report is still valid JSON
+	var output bytes.Buffer
+	writer, _ := New(FormatJSON, &output)
+	writer.Close()
+	if err := json.Unmarshal(output.Bytes(), &records); err != nil || len(records) != 0 {
+		t.Errorf("empty report = %q; want []", output.String())
+	}
+}
+
+func TestCSV(t *testing.T) {
+
+	rows, err := csv.NewReader(bytes.NewReader(writeReport(t, FormatCSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("len(rows) = %d; want 3", len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("header = %v; want %v", rows[0], csvHeader)
+	}
+	if want := "b.jpg,sha256:a,5,,,1,false,duplicate,a.jpg,quarantined,,sdcard"; strings.Join(rows[2], ",") != want {
+		t.Errorf("rows[2] = %s; want %s", strings.Join(rows[2], ","), want)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+
+	lines := strings.Split(strings.TrimSpace(string(writeReport(t, FormatNDJSON))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("len(lines) = %d; want 2", len(lines))
+	}
+
+	var record Record
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record != testRecords[1] {
+		t.Errorf("record = %v; want %v", record, testRecords[1])
+	}
+}
+
+func TestCreate(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "report.ndjson")
+	writer, err := Create(path, FormatFromPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Write(testRecords[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Streamed records are on disk before the report is closed
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(contents, []byte("\n")) {
+		t.Errorf("report = %q; want a complete line", contents)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Create(path, "xml"); err == nil {
+		t.Errorf("Create(xml) error = nil; want an error")
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"github.com/pborman/getopt/v2"
 	"github.com/sirupsen/logrus"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"photo-deduplicator/internal/deduplicator"
+	"sort"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -85,15 +88,6 @@ func main() {
 		panic(err)
 	}
 
-	photoList, err := GetPhotos(directory)
-
-	if err != nil {
-		log.Fatal("Error getting photos list")
-		panic(err)
-	}
-
-	log.Info("Photos to process: ", len(photoList))
-
 	// Channel file names are pushed onto this channel
 	photoChannel := make(chan string)
 	// Wait group to verify all photos have been collected
@@ -126,10 +120,11 @@ func main() {
 	// Spawn the go routine to upload the photos
 	go UploadPhotos(dedupedKeyValueChannel, &uploadWaitGroup, awsSession, &dynamoTableName)
 
-	// Iterate through all the photos
+	// Walk the directory, photos are hashed as soon as they are found
 	log.Info("Iterate through photos")
-	for _, photo := range photoList {
-		photoChannel <- photo
+	if err := GetPhotos(directory, photoChannel); err != nil {
+		log.Fatal("Error getting photos list")
+		panic(err)
 	}
 	close(photoChannel)
 	log.Info("Photo channel closed")
@@ -288,24 +283,42 @@ func UploadPhotos(inputChannel chan pair, waitGroup *sync.WaitGroup, awsSession
 
 }
 
-func GetPhotos(directory string) ([]string, error) {
-	var photos []string
+// Walk a directory and push every photo or video found onto photoChannel as it is discovered
+// Skipped files are counted by reason and summarised once the walk is done
+func GetPhotos(directory string, photoChannel chan string) error {
+	filter := deduplicator.DefaultFilter()
+	skipped := make(map[string]int)
 
-	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
 		// Check errors
 		if err != nil {
 			return err
 		}
 
 		// Not going to include directories
-		if info.IsDir() {
+		if entry.IsDir() {
+			return nil
+		}
+
+		// Weed out file types
+		if reason := filter.Check(directory, path); reason != "" {
+			log.Debug("Skipping ", path, " (", reason, ")")
+			skipped[reason]++
 			return nil
 		}
 
-		// TODO: Weed out file types
-		photos = append(photos, path)
+		photoChannel <- path
 		return nil
 	})
 
-	return photos, err
+	reasons := make([]string, 0, len(skipped))
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		log.Info("Skipped ", skipped[reason], " files (", reason, ")")
+	}
+
+	return err
 }